@@ -6,6 +6,8 @@ import Home from "./components/home/Home";
 import Registration from "./components/registration/Registration";
 import Records from "./components/records/Records";
 import Employment from "./components/employment/Employment";
+import Housing from "./components/housing/Housing";
+import Billing from "./components/billing/Billing";
 import { ProtectedRoute } from "./components/ProtectedRoute";
 import { AuthProvider } from "./hooks/useAuth";
 
@@ -21,6 +23,8 @@ function App() {
         <Route path="/registration" element={<ProtectedRoute><Registration /></ProtectedRoute>} />
         <Route path="/records" element={<ProtectedRoute><Records /></ProtectedRoute>} />
         <Route path="/employment" element={<ProtectedRoute><Employment /></ProtectedRoute>} />
+        <Route path="/housing" element={<ProtectedRoute><Housing /></ProtectedRoute>} />
+        <Route path="/billing" element={<ProtectedRoute><Billing /></ProtectedRoute>} />
       </Routes>
     </AuthProvider>
   );
@@ -0,0 +1,290 @@
+import React, { useState, useEffect } from "react";
+import {
+  Box,
+  Card,
+  CardContent,
+  Typography,
+  Table,
+  TableBody,
+  TableCell,
+  TableHead,
+  TableRow,
+  TextField,
+  MenuItem,
+  Button
+} from "@mui/material";
+import config from "../../config.js";
+
+const formatCents = (cents) => {
+  const sign = cents < 0 ? "-" : "";
+  return `${sign}$${(Math.abs(cents) / 100).toFixed(2)}`;
+};
+
+const Billing = () => {
+  const [terms, setTerms] = useState([]);
+  const [term, setTerm] = useState("");
+  const [statement, setStatement] = useState(null);
+  const [payments, setPayments] = useState([]);
+  const [plans, setPlans] = useState(null);
+  const [amount, setAmount] = useState("");
+  const [token, setToken] = useState("tok_visa");
+  const [paymentKey, setPaymentKey] = useState(crypto.randomUUID());
+  const id = localStorage.getItem("user").slice(1, -1);
+
+  const fetchTerms = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getStatementTerms`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ id: id }),
+      });
+      if (!response.ok) {
+        throw new Error("Failed to fetch statement terms");
+      }
+      const data = await response.json();
+      setTerms(data);
+      if (data.length > 0) {
+        setTerm(data[0]);
+      }
+    } catch (error) {
+      console.error("Error fetching statement terms:", error);
+    }
+  };
+
+  const fetchStatement = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getStatement`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ id: id, term: term }),
+      });
+      if (!response.ok) {
+        throw new Error("Failed to fetch statement");
+      }
+      setStatement(await response.json());
+    } catch (error) {
+      console.error("Error fetching statement:", error);
+      setStatement(null);
+    }
+  };
+
+  const post = async (endpoint, body, headers = {}) => {
+    const response = await fetch(`${config.serverUrl}/${endpoint}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        ...headers,
+      },
+      body: JSON.stringify(body),
+    });
+    if (response.status === 409) {
+      const data = await response.json();
+      window.alert(data.error);
+      return null;
+    }
+    if (!response.ok) {
+      window.alert(await response.text());
+      return null;
+    }
+    return response;
+  };
+
+  const fetchPayments = async () => {
+    try {
+      const paymentsResponse = await post("getPayments", { id: id });
+      if (paymentsResponse) {
+        setPayments(await paymentsResponse.json());
+      }
+      const plansResponse = await post("getPaymentPlans", { id: id });
+      if (plansResponse) {
+        setPlans(await plansResponse.json());
+      }
+    } catch (error) {
+      console.error("Error fetching payments:", error);
+    }
+  };
+
+  const refresh = () => {
+    fetchStatement();
+    fetchPayments();
+  };
+
+  const handlePay = async () => {
+    const cents = Math.round(parseFloat(amount) * 100);
+    if (!(cents > 0)) {
+      window.alert("Enter an amount to pay.");
+      return;
+    }
+    const response = await post("makePayment", { id: id, amountCents: cents, token: token }, { "Idempotency-Key": paymentKey });
+    setPaymentKey(crypto.randomUUID());
+    if (!response) {
+      return;
+    }
+    if (response.status === 202) {
+      window.alert("Your payment is processing and will post shortly.");
+      setTimeout(refresh, 3000);
+    }
+    setAmount("");
+    refresh();
+  };
+
+  const handleEnroll = async (plan) => {
+    if (!window.confirm(`Enroll in the ${plan.name} plan? A $${plan.fee} fee applies.`)) {
+      return;
+    }
+    if (await post("enrollPaymentPlan", { id: id, plan: plan.name })) {
+      refresh();
+    }
+  };
+
+  useEffect(() => {
+    fetchTerms();
+    fetchPayments();
+  }, []);
+
+  useEffect(() => {
+    if (term) {
+      fetchStatement();
+    }
+  }, [term]);
+
+  return (
+    <Box sx={{ display: "flex", flexDirection: "column", gap: 2, p: 3 }}>
+      <Card>
+        <CardContent>
+          <Box sx={{ display: "flex", alignItems: "center", justifyContent: "space-between", mb: 2 }}>
+            <Typography variant="h5">Statement</Typography>
+            <TextField select size="small" label="Term" value={term} onChange={(e) => setTerm(e.target.value)} sx={{ minWidth: 200 }}>
+              {terms.map((t) => (
+                <MenuItem key={t} value={t}>{t}</MenuItem>
+              ))}
+            </TextField>
+          </Box>
+          {statement && (
+            <>
+              <Typography>{`Residency: ${statement.residency}`}</Typography>
+              {statement.term === terms[0] && <Typography>{`Enrolled credits: ${statement.credits}`}</Typography>}
+              <Table size="small" sx={{ mt: 2 }}>
+                <TableHead>
+                  <TableRow>
+                    <TableCell>Date</TableCell>
+                    <TableCell>Description</TableCell>
+                    <TableCell>Type</TableCell>
+                    <TableCell align="right">Amount</TableCell>
+                  </TableRow>
+                </TableHead>
+                <TableBody>
+                  {statement.entries.map((entry) => (
+                    <TableRow key={entry.entryId}>
+                      <TableCell>{new Date(entry.posted).toLocaleDateString()}</TableCell>
+                      <TableCell>{entry.description}</TableCell>
+                      <TableCell>{entry.kind}</TableCell>
+                      <TableCell align="right">{formatCents(entry.amountCents)}</TableCell>
+                    </TableRow>
+                  ))}
+                  <TableRow>
+                    <TableCell colSpan={3}>Term charges</TableCell>
+                    <TableCell align="right">{formatCents(statement.chargesCents)}</TableCell>
+                  </TableRow>
+                  <TableRow>
+                    <TableCell colSpan={3}>Term payments</TableCell>
+                    <TableCell align="right">{formatCents(statement.paymentsCents)}</TableCell>
+                  </TableRow>
+                  <TableRow>
+                    <TableCell colSpan={3}><b>Term balance</b></TableCell>
+                    <TableCell align="right"><b>{formatCents(statement.balanceCents)}</b></TableCell>
+                  </TableRow>
+                  <TableRow>
+                    <TableCell colSpan={3}><b>Account balance</b></TableCell>
+                    <TableCell align="right"><b>{formatCents(statement.accountBalanceCents)}</b></TableCell>
+                  </TableRow>
+                </TableBody>
+              </Table>
+            </>
+          )}
+        </CardContent>
+      </Card>
+      <Card>
+        <CardContent>
+          <Typography variant="h6" sx={{ mb: 1 }}>Make a Payment</Typography>
+          {plans && plans.pastDueCents > 0 && (
+            <Typography color="error" sx={{ mb: 1 }}>{`${formatCents(plans.pastDueCents)} is past due.`}</Typography>
+          )}
+          <Box sx={{ display: "flex", gap: 2, alignItems: "center" }}>
+            <TextField size="small" label="Amount ($)" value={amount} onChange={(e) => setAmount(e.target.value)} />
+            <TextField select size="small" label="Card" value={token} onChange={(e) => setToken(e.target.value)} sx={{ minWidth: 200 }}>
+              <MenuItem value="tok_visa">Test card (approves)</MenuItem>
+              <MenuItem value="tok_pending">Test card (settles later)</MenuItem>
+              <MenuItem value="tok_declined">Test card (declines)</MenuItem>
+            </TextField>
+            <Button variant="contained" onClick={handlePay}>Pay</Button>
+          </Box>
+          {payments.length > 0 && (
+            <Table size="small" sx={{ mt: 2 }}>
+              <TableHead>
+                <TableRow>
+                  <TableCell>Date</TableCell>
+                  <TableCell>Type</TableCell>
+                  <TableCell>Status</TableCell>
+                  <TableCell align="right">Amount</TableCell>
+                </TableRow>
+              </TableHead>
+              <TableBody>
+                {payments.map((p) => (
+                  <TableRow key={p.paymentId}>
+                    <TableCell>{new Date(p.created).toLocaleString()}</TableCell>
+                    <TableCell>{p.kind === "refund" ? "Refund" : "Payment"}</TableCell>
+                    <TableCell>{p.failure ? `${p.status} (${p.failure})` : p.status}</TableCell>
+                    <TableCell align="right">{formatCents(p.amountCents)}</TableCell>
+                  </TableRow>
+                ))}
+              </TableBody>
+            </Table>
+          )}
+        </CardContent>
+      </Card>
+      {plans && (
+        <Card>
+          <CardContent>
+            <Typography variant="h6" sx={{ mb: 1 }}>Payment Plan</Typography>
+            {plans.enrolled ? (
+              <Table size="small">
+                <TableHead>
+                  <TableRow>
+                    <TableCell>Due</TableCell>
+                    <TableCell align="right">Amount</TableCell>
+                    <TableCell align="right">Paid</TableCell>
+                    <TableCell>Status</TableCell>
+                  </TableRow>
+                </TableHead>
+                <TableBody>
+                  {plans.enrolled.installments.map((item) => (
+                    <TableRow key={item.due}>
+                      <TableCell>{new Date(item.due).toLocaleDateString()}</TableCell>
+                      <TableCell align="right">{formatCents(item.amountCents)}</TableCell>
+                      <TableCell align="right">{formatCents(item.paidCents)}</TableCell>
+                      <TableCell>{item.status.replace("_", " ")}</TableCell>
+                    </TableRow>
+                  ))}
+                </TableBody>
+              </Table>
+            ) : (
+              plans.available.map((plan) => (
+                <Box key={plan.name} sx={{ display: "flex", alignItems: "center", gap: 2 }}>
+                  <Typography>{`${plan.name}: ${plan.description} ($${plan.fee} fee)`}</Typography>
+                  <Button size="small" onClick={() => handleEnroll(plan)}>Enroll</Button>
+                </Box>
+              ))
+            )}
+          </CardContent>
+        </Card>
+      )}
+    </Box>
+  );
+};
+
+export default Billing;
@@ -123,6 +123,32 @@ const Dashboard = () => {
             EMPLOYMENT SERVICES
           </Button>
         </Link>
+        <Link to="/housing" style={{ textDecoration: 'none', display: 'flex', height: '100%' }}>
+          <Button
+            sx={{
+              color: 'white',
+              fontSize: '25px',
+              '&:hover': {
+                backgroundColor: '#470000',
+              },
+            }}
+          >
+            HOUSING
+          </Button>
+        </Link>
+        <Link to="/billing" style={{ textDecoration: 'none', display: 'flex', height: '100%' }}>
+          <Button
+            sx={{
+              color: 'white',
+              fontSize: '25px',
+              '&:hover': {
+                backgroundColor: '#470000',
+              },
+            }}
+          >
+            BILLING
+          </Button>
+        </Link>
       </Box>
     </Box>
   );
@@ -1,6 +1,6 @@
 import React, { useState, useEffect } from 'react';
 import { DataGrid } from '@mui/x-data-grid';
-import { Typography, Card, Box, CardContent, Button, Dialog, DialogTitle, DialogActions, DialogContent, DialogContentText } from '@mui/material';
+import { Typography, Card, Box, CardContent, Button, Dialog, DialogTitle, DialogActions, DialogContent, DialogContentText, TextField, MenuItem } from '@mui/material';
 import DeleteIcon from '@mui/icons-material/Delete';
 import AddIcon from '@mui/icons-material/Add';
 import { DatePicker } from '@mui/x-date-pickers/DatePicker';
@@ -22,13 +22,91 @@ const Employment = () => {
   const [openDeleteDialog, setOpenDeleteDialog] = useState(false);
   const [rowToDelete, setRowToDelete] = useState(null);
   const [dialogOpen, setDialogOpen] = useState(false);
-  const [isModified, setIsModified] = useState(false);
+  const [openPunch, setOpenPunch] = useState(null);
+  const [jobs, setJobs] = useState([]);
+  const [jobId, setJobId] = useState('');
+  const [periods, setPeriods] = useState([]);
   const id = localStorage.getItem("user").slice(1, -1);
 
+  const fetchJobs = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getJobs`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ id: id }),
+      });
+      if (!response.ok) {
+        throw new Error("Failed to fetch jobs");
+      }
+      const data = await response.json();
+      setJobs(data);
+      if (data.length > 0) {
+        setJobId((current) => current || data[0].jobId);
+      }
+    } catch (error) {
+      console.error("Error fetching jobs:", error);
+    }
+  };
+
+  const fetchTimesheetData = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getTimesheet`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ id: id }),
+      });
+      if (!response.ok) {
+        throw new Error("Failed to fetch timesheet data");
+      }
+      const data = await response.json();
+      setPeriods(data);
+      const formattedRows = data
+        .flatMap((period) => period.entries)
+        .map((entry) => ({
+          id: entry.entryId,
+          version: entry.version,
+          status: entry.status,
+          timeIn: new Date(entry.timeIn),
+          timeOut: new Date(entry.timeOut),
+          jobId: entry.jobId,
+          flags: entry.flags,
+        }))
+        .sort((a, b) => a.timeIn - b.timeIn);
+      setRows(formattedRows);
+    } catch (error) {
+      console.error("Error fetching timesheet data:", error);
+    }
+  };
+
+  const handleSubmitPeriod = (start) => {
+    fetch(`${config.serverUrl}/submitTimesheet`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ id: id, start: start }),
+    }).then(async (response) => {
+      if (response.ok) {
+        fetchTimesheetData();
+      } else if (response.status === 409) {
+        const data = await response.json();
+        window.alert(data.error);
+      } else {
+        console.error('Failed to submit timesheet');
+      }
+    }).catch((error) => {
+      console.error('Error:', error);
+    });
+  };
+
   useEffect(() => {
-    const fetchTimesheetData = async () => {
+    const fetchPunch = async () => {
       try {
-        const response = await fetch(`${config.serverUrl}/getTimesheet`, {
+        const response = await fetch(`${config.serverUrl}/getPunch`, {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
@@ -36,27 +114,55 @@ const Employment = () => {
           body: JSON.stringify({ id: id }),
         });
         if (!response.ok) {
-          throw new Error("Failed to fetch timesheet data");
-        }
-        const data = await response.json();
-        if (data != null) {
-          const formattedRows = data.map((entry, index) => ({
-            id: index,
-            status: entry.status,
-            timeIn: new Date(entry.timeIn),
-            timeOut: new Date(entry.timeOut),
-          }));
-          setRows(formattedRows);
-        } else {
-          setRows([]);
+          throw new Error("Failed to fetch punch");
         }
+        setOpenPunch(await response.json());
       } catch (error) {
-        console.error("Error fetching timesheet data:", error);
+        console.error("Error fetching punch:", error);
       }
     };
     fetchTimesheetData();
+    fetchPunch();
+    fetchJobs();
   }, []);  
 
+  const handlePunch = () => {
+    fetch(`${config.serverUrl}/${openPunch ? 'clockOut' : 'clockIn'}`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ id: id, jobId: jobId }),
+    }).then(async (response) => {
+      if (response.status === 400) {
+        await handleEntryError(response);
+        return;
+      }
+      if (response.status === 409) {
+        const data = await response.json();
+        window.alert(data.error);
+        if (openPunch) {
+          setOpenPunch(null);
+        }
+        return;
+      }
+      if (!response.ok) {
+        console.error('Failed to punch');
+        return;
+      }
+      const data = await response.json();
+      if (openPunch) {
+        setOpenPunch(null);
+        fetchTimesheetData();
+        fetchJobs();
+      } else {
+        setOpenPunch(data);
+      }
+    }).catch((error) => {
+      console.error('Error:', error);
+    });
+  };
+
   const openAdd = () => {
     setAddOpened(true);
   };
@@ -188,24 +294,51 @@ const Employment = () => {
     }
     return rows.map((row) => ({
       id: row.id,
+      job: row.id !== 'add' ? (jobs.find((job) => job.jobId === row.jobId) || {}).title : '',
       date: row.id !== 'add' ? `${row.timeIn.getMonth() + 1}/${row.timeIn.getDate()}/${row.timeIn.getFullYear()}` : '',
       timeIn: row.id !== 'add' ? returnTimeStr(row.timeIn) : '',
       timeOut: row.id !== 'add' ? returnTimeStr(row.timeOut) : '',
       hours: row.id !== 'add' ? calculateHours(row.timeIn, row.timeOut) : '',
-      status: row.id !== 'add' ? row.status : '',
+      status: row.id !== 'add' ? (row.flags && row.flags.length > 0 ? `${row.status || ''} ⚑`.trim() : row.status) : '',
     }));
   };
 
-  const handleDeleteRow = (id) => {
-    const rowToDelete = rows.find((row) => row.id === id);
-    if (rowToDelete && rowToDelete.status === 'A') {
+  const handleEntryError = async (response) => {
+    if (response.status === 400) {
+      const data = await response.json();
+      window.alert(data.errors.map((error) => error.message).join('\n'));
+    } else if (response.status === 412) {
+      window.alert('This entry was changed in another window. Reload the page and try again.');
+    } else {
+      console.error('Failed to save timesheet entry');
+    }
+  };
+
+  const handleDeleteRow = (entryId) => {
+    const rowToDelete = rows.find((row) => row.id === entryId);
+    if (rowToDelete && (rowToDelete.status === 'A' || rowToDelete.status === 'S')) {
       setRowToDelete(rowToDelete);
       setOpenDeleteDialog(true);
-    } else {
-      setRows((prevRows) => prevRows.filter((row) => row.id !== id));
-      setIsModified(true);
+      return;
     }
-  };  
+    fetch(`${config.serverUrl}/deleteTimesheetEntry`, {
+      method: 'DELETE',
+      headers: {
+        'Content-Type': 'application/json',
+        'If-Match': `"${rowToDelete.version}"`,
+      },
+      body: JSON.stringify({ id: id, entryId: entryId }),
+    }).then(async (response) => {
+      if (response.ok) {
+        fetchTimesheetData();
+        fetchJobs();
+      } else {
+        await handleEntryError(response);
+      }
+    }).catch((error) => {
+      console.error('Error:', error);
+    });
+  };
 
   const handleAddRow = (date, timeIn, timeOut) => {
     let dateIn = new Date(date);
@@ -214,26 +347,19 @@ const Employment = () => {
     dateIn.setMinutes(timeIn.getMinutes());
     dateOut.setHours(timeOut.getHours());
     dateOut.setMinutes(timeOut.getMinutes());
-    setRows((prevRows) => [...prevRows, { id: prevRows.length, timeIn: dateIn, timeOut: dateOut, status: '' }]);
-    setIsModified(true);
-  };
-
-  const handleSave = () => {
-    const timesheetData = rows
-      .filter((row) => row.id !== 'add')
-      .map(({ id, delete: _, ...rest }) => rest);
-    fetch(`${config.serverUrl}/saveTimesheet`, {
-      method: 'PUT',
+    fetch(`${config.serverUrl}/createTimesheetEntry`, {
+      method: 'POST',
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ timesheet: timesheetData, id: id }),
-    }).then((response) => {
+      body: JSON.stringify({ id: id, jobId: jobId, timeIn: dateIn.toISOString(), timeOut: dateOut.toISOString() }),
+    }).then(async (response) => {
       if (response.ok) {
+        fetchTimesheetData();
+        fetchJobs();
         setDialogOpen(true);
-        setIsModified(false);
       } else {
-        console.error('Failed to save timesheet');
+        await handleEntryError(response);
       }
     }).catch((error) => {
       console.error('Error:', error);
@@ -286,6 +412,7 @@ const Employment = () => {
             rows={[...displayRows(rows), { id: 'add' }]}
             columns={[
               { field: 'date', headerName: 'Date', flex: 2 },
+              { field: 'job', headerName: 'Job', flex: 2 },
               { field: 'timeIn', headerName: 'Time In', flex: 2 },
               { field: 'timeOut', headerName: 'Time Out', flex: 2 },
               { field: 'hours', headerName: 'Hours', flex: 1, type: 'number', align: 'center', headerAlign: 'center' },
@@ -370,11 +497,28 @@ const Employment = () => {
             <Typography variant="body1">
               Total Hours: {totalHours.toFixed(2)}
             </Typography>
+            {jobs.length > 1 && (
+              <TextField
+                select
+                size="small"
+                label="Job"
+                value={jobId}
+                onChange={(event) => setJobId(event.target.value)}
+                sx={{ minWidth: 200 }}
+              >
+                {jobs.map((job) => (
+                  <MenuItem key={job.jobId} value={job.jobId}>{job.title}</MenuItem>
+                ))}
+              </TextField>
+            )}
+            {openPunch && (
+              <Typography variant="body2">
+                Clocked in since {returnTimeStr(new Date(openPunch.clockIn))}
+              </Typography>
+            )}
             <Button
-              onClick={handleSave}
-              disabled={!isModified}
+              onClick={handlePunch}
               variant="contained"
-              color="primary"
               sx={{
                 backgroundColor: '#800000',
                 '&:hover': {
@@ -382,16 +526,111 @@ const Employment = () => {
                 },
               }}
             >
-              Save
+              {openPunch ? 'Clock Out' : 'Clock In'}
             </Button>
           </Box>
         </CardContent>
       </Card>
+      {periods.length > 0 && (
+        <Card
+          sx={{
+            width: 600,
+            backgroundColor: '#ffffff',
+            borderRadius: 1,
+            boxShadow: 3,
+            mt: 2,
+          }}
+        >
+          <Box
+            sx={{
+              backgroundColor: '#800000',
+              color: '#ffffff',
+              p: 1,
+              borderTopLeftRadius: 4,
+              borderTopRightRadius: 4,
+            }}
+          >
+            <Typography variant="h6" fontWeight="bold">
+              Pay Periods
+            </Typography>
+          </Box>
+          <CardContent>
+            {periods.filter((period) => period.start).map((period) => (
+              <Box key={period.start} sx={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', mb: 1 }}>
+                <Box>
+                  <Typography variant="body1" fontWeight="bold">
+                    {period.start} to {period.end} ({period.status})
+                  </Typography>
+                  <Typography variant="body2">
+                    {period.hours.toFixed(2)} hours · ${period.earnings.toFixed(2)} · submit by {new Date(period.submitBy).toLocaleString()}
+                  </Typography>
+                </Box>
+                {!period.locked && (period.status === 'draft' || period.status === 'rejected') && period.entries.length > 0 && (
+                  <Button
+                    onClick={() => handleSubmitPeriod(period.start)}
+                    variant="contained"
+                    sx={{
+                      backgroundColor: '#800000',
+                      '&:hover': {
+                        backgroundColor: '#470000',
+                      },
+                    }}
+                  >
+                    Submit
+                  </Button>
+                )}
+              </Box>
+            ))}
+          </CardContent>
+        </Card>
+      )}
+      {jobs.length > 0 && (
+        <Card
+          sx={{
+            width: 600,
+            backgroundColor: '#ffffff',
+            borderRadius: 1,
+            boxShadow: 3,
+            mt: 2,
+          }}
+        >
+          <Box
+            sx={{
+              backgroundColor: '#800000',
+              color: '#ffffff',
+              p: 1,
+              borderTopLeftRadius: 4,
+              borderTopRightRadius: 4,
+            }}
+          >
+            <Typography variant="h6" fontWeight="bold">
+              Jobs
+            </Typography>
+          </Box>
+          <CardContent>
+            {jobs.map((job) => (
+              <Box key={job.jobId} sx={{ mb: 1 }}>
+                <Typography variant="body1" fontWeight="bold">
+                  {job.title}, {job.department}
+                </Typography>
+                <Typography variant="body2">
+                  ${job.hourlyRate.toFixed(2)}/hr · {job.hours.toFixed(2)} hours · ${job.earnings.toFixed(2)} earned
+                </Typography>
+                {job.awardCap && (
+                  <Typography variant="body2" sx={{ color: job.warning ? 'red' : 'inherit' }}>
+                    ${job.remaining.toFixed(2)} left of your ${job.awardCap.toFixed(2)} Work-Study award
+                  </Typography>
+                )}
+              </Box>
+            ))}
+          </CardContent>
+        </Card>
+      )}
       <Dialog open={dialogOpen} onClose={handleDialogClose}>
         <DialogTitle sx={{ backgroundColor: '#800000', color: 'white' }}>Success</DialogTitle>
         <DialogContent sx={{ display: 'flex', flexDirection: 'column' }}>
           <DialogContentText sx={{ mt: 2, color: 'black' }}>
-            Timesheet successfully saved.
+            Timesheet entry successfully saved.
           </DialogContentText>
         </DialogContent>
         <DialogActions>
@@ -416,7 +655,7 @@ const Employment = () => {
         </DialogTitle>
         <DialogContent sx={{ mt: 1 }}>
           <Typography>
-            You cannot delete rows that have been approved
+            You cannot delete rows that have been submitted or approved
           </Typography>
         </DialogContent>
         <DialogActions>
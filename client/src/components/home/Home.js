@@ -2,13 +2,19 @@ import React, { useState, useEffect } from "react";
 import { Box, Card, CardContent, Typography, Table, TableBody, TableRow, TableCell } from "@mui/material";
 import config from "../../config.js";
 
-const formatter = new Intl.DateTimeFormat('en-US', { month: 'long', day: 'numeric', year: 'numeric', hour: 'numeric', minute: '2-digit', hour12: true, timeZone: "UTC" });
+const formatZoned = (zoned) => {
+  if (!zoned) {
+    return '';
+  }
+  const formatter = new Intl.DateTimeFormat('en-US', { month: 'long', day: 'numeric', year: 'numeric', hour: 'numeric', minute: '2-digit', hour12: true, timeZone: zoned.timeZone, timeZoneName: 'short' });
+  return formatter.format(new Date(zoned.time));
+};
 
 const Home = () => {
   const [enrollmentDate, setEnrollmentDate] = useState(null);
   const [housingDate, setHousingDate] = useState(null);
 
-  const holds = ["Mandatory Health Insurance"];
+  const [holds, setHolds] = useState([]);
   const todo = ["Complete Health Waiver by August 26th, 2024"];
   const id = localStorage.getItem("user").slice(1, -1);
   
@@ -26,7 +32,7 @@ const Home = () => {
           throw new Error("Failed to fetch enrollment date");
         }
         const enrollmentData = await enrollmentResponse.json();
-        setEnrollmentDate(new Date(enrollmentData));
+        setEnrollmentDate(enrollmentData);
         const housingResponse = await fetch(`${config.serverUrl}/getHousingDate`, {
           method: "POST",
           headers: {
@@ -38,12 +44,30 @@ const Home = () => {
           throw new Error("Failed to fetch housing date");
         }
         const housingData = await housingResponse.json();
-        setHousingDate(new Date(housingData));
+        setHousingDate(housingData);
       } catch (error) {
         console.error("Error fetching dates:", error);
       }
     };
+    const fetchHolds = async () => {
+      try {
+        const response = await fetch(`${config.serverUrl}/getHolds`, {
+          method: "POST",
+          headers: {
+            "Content-Type": "application/json",
+          },
+          body: JSON.stringify({ id: id }),
+        });
+        if (!response.ok) {
+          throw new Error("Failed to fetch holds");
+        }
+        setHolds(await response.json());
+      } catch (error) {
+        console.error("Error fetching holds:", error);
+      }
+    };
     fetchDates();
+    fetchHolds();
   }, []);
 
   return (
@@ -82,9 +106,15 @@ const Home = () => {
         <CardContent>
           <Table>
             <TableBody>
-              {holds.map((hold, idx) => (
-                <TableRow key={idx}>
-                  <TableCell>{hold}</TableCell>
+              {holds.length === 0 && (
+                <TableRow>
+                  <TableCell>No holds</TableCell>
+                </TableRow>
+              )}
+              {holds.map((hold) => (
+                <TableRow key={hold.holdId}>
+                  <TableCell sx={{ textTransform: "capitalize" }}>{hold.type}</TableCell>
+                  <TableCell>{hold.reason}</TableCell>
                 </TableRow>
               ))}
             </TableBody>
@@ -153,10 +183,10 @@ const Home = () => {
           <Table>
             <TableBody>
               <TableRow>
-                <TableCell>{`Class Enrollment: ${formatter.format(enrollmentDate)}`}</TableCell>
+                <TableCell>{`Class Enrollment: ${formatZoned(enrollmentDate)}`}</TableCell>
               </TableRow>
               <TableRow>
-                <TableCell>{`Housing Registration: ${formatter.format(housingDate)}`}</TableCell>
+                <TableCell>{`Housing Registration: ${formatZoned(housingDate)}`}</TableCell>
               </TableRow>
             </TableBody>
           </Table>
@@ -0,0 +1,332 @@
+import React, { useState, useEffect } from "react";
+import {
+  Box,
+  Card,
+  CardContent,
+  Typography,
+  Button,
+  Table,
+  TableBody,
+  TableCell,
+  TableHead,
+  TableRow,
+  TextField,
+  Checkbox,
+  FormControlLabel,
+  MenuItem
+} from "@mui/material";
+import config from "../../config.js";
+
+const formatZoned = (zoned) => {
+  if (!zoned) {
+    return '';
+  }
+  const formatter = new Intl.DateTimeFormat('en-US', { month: 'long', day: 'numeric', year: 'numeric', hour: 'numeric', minute: '2-digit', hour12: true, timeZone: zoned.timeZone, timeZoneName: 'short' });
+  return formatter.format(new Date(zoned.time));
+};
+
+const splitList = (value) => value.split(",").map((item) => item.trim()).filter((item) => item !== "");
+
+const Housing = () => {
+  const [housing, setHousing] = useState(null);
+  const [rooms, setRooms] = useState([]);
+  const [availableOnly, setAvailableOnly] = useState(true);
+  const [buildings, setBuildings] = useState("");
+  const [roomTypes, setRoomTypes] = useState("");
+  const [notes, setNotes] = useState("");
+  const [inviteId, setInviteId] = useState("");
+  const [traits, setTraits] = useState([]);
+  const [questionnaire, setQuestionnaire] = useState({ gender: "", genderInclusive: false, needs: "", answers: {} });
+  const id = localStorage.getItem("user").slice(1, -1);
+
+  const post = async (endpoint, body) => {
+    const response = await fetch(`${config.serverUrl}/${endpoint}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify(body),
+    });
+    if (response.status === 409) {
+      const data = await response.json();
+      window.alert(data.error);
+      return null;
+    }
+    if (!response.ok) {
+      window.alert(await response.text());
+      return null;
+    }
+    return response;
+  };
+
+  const fetchHousing = async () => {
+    try {
+      const response = await post("getHousing", { id: id });
+      if (!response) {
+        return;
+      }
+      const data = await response.json();
+      setHousing(data);
+      if (data.application) {
+        setBuildings(data.application.preferences.buildings.join(", "));
+        setRoomTypes(data.application.preferences.roomTypes.join(", "));
+        setNotes(data.application.preferences.notes || "");
+      }
+    } catch (error) {
+      console.error("Error fetching housing:", error);
+    }
+  };
+
+  const fetchRooms = async () => {
+    try {
+      const response = await post("getRooms", { id: id, available: availableOnly });
+      if (!response) {
+        return;
+      }
+      setRooms(await response.json());
+    } catch (error) {
+      console.error("Error fetching rooms:", error);
+    }
+  };
+
+  const fetchQuestionnaire = async () => {
+    try {
+      const response = await post("getRoommateQuestionnaire", { id: id });
+      if (!response) {
+        return;
+      }
+      const data = await response.json();
+      setTraits(data.traits);
+      if (data.questionnaire) {
+        setQuestionnaire({ ...data.questionnaire, needs: data.questionnaire.needs.join(", ") });
+      }
+    } catch (error) {
+      console.error("Error fetching questionnaire:", error);
+    }
+  };
+
+  useEffect(() => {
+    fetchHousing();
+    fetchQuestionnaire();
+  }, []);
+
+  useEffect(() => {
+    fetchRooms();
+  }, [availableOnly]);
+
+  const refresh = () => {
+    fetchHousing();
+    fetchRooms();
+  };
+
+  const handleSaveApplication = async () => {
+    const preferences = { buildings: splitList(buildings), roomTypes: splitList(roomTypes), notes: notes };
+    if (await post("saveHousingApplication", { id: id, preferences: preferences })) {
+      refresh();
+    }
+  };
+
+  const handleSaveQuestionnaire = async () => {
+    const body = { ...questionnaire, needs: splitList(questionnaire.needs) };
+    if (await post("saveRoommateQuestionnaire", { id: id, questionnaire: body })) {
+      fetchQuestionnaire();
+    }
+  };
+
+  const handleClaim = async (room, forGroup) => {
+    const members = forGroup && housing.group ? housing.group.members : [id];
+    const free = room.beds.filter((bed) => !bed.occupied);
+    if (free.length < members.length) {
+      window.alert(`This room only has ${free.length} open bed(s).`);
+      return;
+    }
+    const claims = members.map((member, i) => ({ id: member, bedId: free[i].bedId }));
+    if (await post("claimBeds", { id: id, claims: claims })) {
+      refresh();
+    }
+  };
+
+  const handleRelease = async () => {
+    if (!window.confirm("Give up your bed? Someone else may claim it.")) {
+      return;
+    }
+    if (await post("releaseBed", { id: id })) {
+      refresh();
+    }
+  };
+
+  const handleCreateGroup = async () => {
+    if (await post("createHousingGroup", { id: id })) {
+      fetchHousing();
+    }
+  };
+
+  const handleInvite = async () => {
+    if (await post("inviteToHousingGroup", { id: id, memberId: inviteId })) {
+      setInviteId("");
+      fetchHousing();
+    }
+  };
+
+  const handleJoin = async (groupId) => {
+    if (await post("joinHousingGroup", { id: id, groupId: groupId })) {
+      fetchHousing();
+    }
+  };
+
+  const handleLeave = async () => {
+    if (await post("leaveHousingGroup", { id: id })) {
+      fetchHousing();
+    }
+  };
+
+  if (!housing) {
+    return null;
+  }
+
+  const canClaim = housing.open && housing.application && !housing.assignment;
+
+  return (
+    <Box sx={{ display: "flex", flexDirection: "column", gap: 2, p: 3 }}>
+      <Card>
+        <CardContent>
+          <Typography variant="h5" sx={{ mb: 1 }}>Housing Selection</Typography>
+          <Typography>
+            {housing.open ? "Room selection is open." : `Room selection opens ${formatZoned(housing.opens)}.`}
+          </Typography>
+          {housing.assignment && (
+            <Box sx={{ display: "flex", alignItems: "center", gap: 2, mt: 1 }}>
+              <Typography>
+                {`Assigned: ${housing.assignment.building} ${housing.assignment.room}, bed ${housing.assignment.bed} (${housing.assignment.roomType}, $${housing.assignment.rate})`}
+              </Typography>
+              <Button variant="outlined" onClick={handleRelease}>Release Bed</Button>
+            </Box>
+          )}
+        </CardContent>
+      </Card>
+      <Card>
+        <CardContent>
+          <Typography variant="h6" sx={{ mb: 1 }}>Application</Typography>
+          <Box sx={{ display: "flex", gap: 2, flexWrap: "wrap" }}>
+            <TextField label="Buildings (most preferred first)" value={buildings} onChange={(e) => setBuildings(e.target.value)} sx={{ minWidth: 320 }} />
+            <TextField label="Room types" value={roomTypes} onChange={(e) => setRoomTypes(e.target.value)} />
+            <TextField label="Notes" value={notes} onChange={(e) => setNotes(e.target.value)} sx={{ minWidth: 320 }} />
+            <Button variant="contained" onClick={handleSaveApplication}>
+              {housing.application ? "Update Application" : "Submit Application"}
+            </Button>
+          </Box>
+        </CardContent>
+      </Card>
+      <Card>
+        <CardContent>
+          <Typography variant="h6" sx={{ mb: 1 }}>Roommate Group</Typography>
+          {housing.group ? (
+            <Box sx={{ display: "flex", flexDirection: "column", gap: 1 }}>
+              <Typography>{`Leader: ${housing.group.leader}`}</Typography>
+              <Typography>{`Members: ${housing.group.members.join(", ")}`}</Typography>
+              {housing.group.invited.length > 0 && (
+                <Typography>{`Invited: ${housing.group.invited.join(", ")}`}</Typography>
+              )}
+              <Box sx={{ display: "flex", gap: 2 }}>
+                <TextField size="small" label="Student ID" value={inviteId} onChange={(e) => setInviteId(e.target.value)} />
+                <Button variant="outlined" onClick={handleInvite}>Invite</Button>
+                <Button color="error" onClick={handleLeave}>Leave Group</Button>
+              </Box>
+            </Box>
+          ) : (
+            <Box sx={{ display: "flex", flexDirection: "column", gap: 1 }}>
+              <Button variant="outlined" sx={{ alignSelf: "flex-start" }} onClick={handleCreateGroup}>Create Group</Button>
+              {housing.invites.map((invite) => (
+                <Box key={invite.groupId} sx={{ display: "flex", alignItems: "center", gap: 2 }}>
+                  <Typography>{`${invite.leader} invited you (${invite.members.length} member(s))`}</Typography>
+                  <Button size="small" onClick={() => handleJoin(invite.groupId)}>Join</Button>
+                </Box>
+              ))}
+            </Box>
+          )}
+        </CardContent>
+      </Card>
+      {!housing.group && (
+        <Card>
+          <CardContent>
+            <Typography variant="h6">Roommate Matching</Typography>
+            <Typography variant="body2" sx={{ mb: 1 }}>
+              Students without a roommate group are matched by housing staff using these answers (1 = low/early, 5 = high/late).
+            </Typography>
+            <Box sx={{ display: "flex", gap: 2, flexWrap: "wrap", alignItems: "center" }}>
+              <TextField label="Gender" value={questionnaire.gender} onChange={(e) => setQuestionnaire({ ...questionnaire, gender: e.target.value })} />
+              <FormControlLabel
+                control={<Checkbox checked={questionnaire.genderInclusive} onChange={(e) => setQuestionnaire({ ...questionnaire, genderInclusive: e.target.checked })} />}
+                label="Gender-inclusive housing"
+              />
+              <TextField label="Needs (e.g. service_animal)" value={questionnaire.needs} onChange={(e) => setQuestionnaire({ ...questionnaire, needs: e.target.value })} />
+              {traits.map((trait) => (
+                <TextField
+                  key={trait}
+                  select
+                  label={trait}
+                  value={questionnaire.answers[trait] || ""}
+                  onChange={(e) => setQuestionnaire({ ...questionnaire, answers: { ...questionnaire.answers, [trait]: e.target.value } })}
+                  sx={{ minWidth: 140 }}
+                >
+                  {[1, 2, 3, 4, 5].map((value) => (
+                    <MenuItem key={value} value={value}>{value}</MenuItem>
+                  ))}
+                </TextField>
+              ))}
+              <Button variant="contained" onClick={handleSaveQuestionnaire}>Save Answers</Button>
+            </Box>
+          </CardContent>
+        </Card>
+      )}
+      <Card>
+        <CardContent>
+          <Box sx={{ display: "flex", alignItems: "center", justifyContent: "space-between" }}>
+            <Typography variant="h6">Rooms</Typography>
+            <FormControlLabel
+              control={<Checkbox checked={availableOnly} onChange={(e) => setAvailableOnly(e.target.checked)} />}
+              label="Only rooms with open beds"
+            />
+          </Box>
+          <Table size="small">
+            <TableHead>
+              <TableRow>
+                <TableCell>Building</TableCell>
+                <TableCell>Room</TableCell>
+                <TableCell>Floor</TableCell>
+                <TableCell>Type</TableCell>
+                <TableCell>Rate</TableCell>
+                <TableCell>Open Beds</TableCell>
+                <TableCell />
+              </TableRow>
+            </TableHead>
+            <TableBody>
+              {rooms.map((room) => (
+                <TableRow key={`${room.building}-${room.room}`}>
+                  <TableCell>{room.building}</TableCell>
+                  <TableCell>{room.room}</TableCell>
+                  <TableCell>{room.floor}</TableCell>
+                  <TableCell>{room.roomType}</TableCell>
+                  <TableCell>{`$${room.rate}`}</TableCell>
+                  <TableCell>{`${room.available} / ${room.capacity}`}</TableCell>
+                  <TableCell>
+                    <Button size="small" disabled={!canClaim || room.available === 0} onClick={() => handleClaim(room, false)}>
+                      Claim Bed
+                    </Button>
+                    {housing.group && housing.group.members.length > 1 && (
+                      <Button size="small" disabled={!canClaim || room.available < housing.group.members.length} onClick={() => handleClaim(room, true)}>
+                        Claim for Group
+                      </Button>
+                    )}
+                  </TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        </CardContent>
+      </Card>
+    </Box>
+  );
+};
+
+export default Housing;
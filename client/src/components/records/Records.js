@@ -31,6 +31,11 @@ const Records = () => {
   const [selectedRecordType, setSelectedRecordType] = useState("Covid-19 Immunization Record");
   const [file, setFile] = useState(null);
   const [fileName, setFileName] = useState("");
+  const [versions, setVersions] = useState([]);
+  const [versionsRecord, setVersionsRecord] = useState(null);
+  const [requiredDocuments, setRequiredDocuments] = useState([]);
+  const [audits, setAudits] = useState([]);
+  const [sbcProgress, setSbcProgress] = useState(null);
   const id = localStorage.getItem("user").slice(1, -1);
 
   const fetchUnofficialTranscript = async () => {
@@ -43,6 +48,12 @@ const Records = () => {
         body: JSON.stringify({ id: id }),
       });
 
+      if (response.status === 409) {
+        const data = await response.json();
+        window.alert(data.error);
+        setTranscript([]);
+        return;
+      }
       if (!response.ok) {
         throw new Error("Failed to fetch unofficial transcript");
       }
@@ -91,8 +102,62 @@ const Records = () => {
     }
   };
 
+  const fetchRequiredDocuments = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getRequiredDocuments`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: id }),
+      });
+      if (!response.ok) throw new Error("Failed to fetch required documents");
+      setRequiredDocuments(await response.json());
+    } catch (error) {
+      console.error("Error fetching required documents:", error);
+      setRequiredDocuments([]);
+    }
+  };
+
+  const fetchDegreeAudit = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getDegreeAudit`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: id }),
+      });
+      if (!response.ok) throw new Error("Failed to fetch degree audit");
+      setAudits(await response.json());
+    } catch (error) {
+      console.error("Error fetching degree audit:", error);
+      setAudits([]);
+    }
+  };
+
+  const fetchSBCProgress = async () => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getSBCProgress`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: id }),
+      });
+      if (!response.ok) throw new Error("Failed to fetch SBC progress");
+      setSbcProgress(await response.json());
+    } catch (error) {
+      console.error("Error fetching SBC progress:", error);
+      setSbcProgress(null);
+    }
+  };
+
+  const statusColor = (status) => {
+    if (status === "satisfied") return "#2e7d32";
+    if (status === "in_progress") return "#ed6c02";
+    return "#d32f2f";
+  };
+
   useEffect(() => {
     fetchOtherRecords();
+    fetchRequiredDocuments();
+    fetchDegreeAudit();
+    fetchSBCProgress();
   }, []);
 
   const handleUploadDialogOpen = () => setOpenUploadDialog(true);
@@ -111,33 +176,44 @@ const Records = () => {
     }
   };
 
-  const handleUpload = async () => {
+  const handleUpload = async (overwrite = false) => {
     const formData = new FormData();
     formData.append("id", id);
-    formData.append("file", file);
     formData.append("filename", selectedRecordType);
+    formData.append("overwrite", overwrite ? "true" : "false");
+    formData.append("file", file);
     try {
       const response = await fetch(`${config.serverUrl}/putRecord`, {
         method: "PUT",
         body: formData,
       });
+      if (response.status === 409 && !overwrite) {
+        if (window.confirm(`You already uploaded a ${selectedRecordType}. Upload this as a new version?`)) {
+          await handleUpload(true);
+        }
+        return;
+      }
       if (!response.ok) {
+        const message = await response.text();
+        window.alert(message || "Failed to upload file");
         throw new Error("Failed to upload file");
       }
       handleUploadDialogClose();
+      fetchOtherRecords();
+      fetchRequiredDocuments();
     } catch (error) {
       console.error("Error uploading file:", error);
     }
   };  
 
-  const downloadRecord = async (filename) => {
+  const downloadRecord = async (filename, version = 0) => {
     try {
       const response = await fetch(`${config.serverUrl}/getRecord`, {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
         },
-        body: JSON.stringify({ id: id, filename: filename }),
+        body: JSON.stringify({ id: id, filename: filename, version: version }),
       });
 
       if (!response.ok) {
@@ -156,6 +232,41 @@ const Records = () => {
     }
   };
 
+  const openVersions = async (filename) => {
+    try {
+      const response = await fetch(`${config.serverUrl}/getRecordVersions`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: id, filename: filename }),
+      });
+      if (!response.ok) throw new Error("Failed to fetch record versions");
+      setVersions(await response.json());
+      setVersionsRecord(filename);
+    } catch (error) {
+      console.error("Error fetching record versions:", error);
+    }
+  };
+
+  const deleteRecord = async (filename) => {
+    if (!window.confirm(`Delete ${filename}? It can be restored by an administrator until it is purged.`)) {
+      return;
+    }
+    try {
+      const response = await fetch(`${config.serverUrl}/deleteRecord`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: id, filename: filename }),
+      });
+      if (!response.ok) throw new Error("Failed to delete record");
+      const record = await response.json();
+      window.alert(`${filename} will be permanently removed on ${new Date(record.purgeAfter).toLocaleDateString()}.`);
+      fetchOtherRecords();
+      fetchRequiredDocuments();
+    } catch (error) {
+      console.error("Error deleting record:", error);
+    }
+  };
+
   const handleDialogOpen = () => {
     fetchUnofficialTranscript();
     fetchGPA();
@@ -193,6 +304,136 @@ const Records = () => {
           >View Unofficial Transcript</Button>
         </CardContent>
       </Card>
+      {audits.map((audit) => (
+        <Card key={audit.major} sx={{ width: "80%", maxWidth: 600, borderRadius: 1, boxShadow: 3 }}>
+          <Box sx={{ backgroundColor: "#800000", color: "#fff", p: 1, borderRadius: "4px 4px 0 0" }}>
+            <Typography variant="h6" fontWeight="bold">Degree Audit: {audit.name || audit.major}</Typography>
+          </Box>
+          <CardContent>
+            {audit.status === "unavailable" ? (
+              <Typography>Requirements for this major are not available.</Typography>
+            ) : (
+              <>
+                <Typography sx={{ mb: 1 }}>
+                  Credits: {audit.earnedCredits} earned, {audit.inProgressCredits} in progress of {audit.totalCredits}
+                </Typography>
+                <Table>
+                  <TableBody>
+                    {audit.requirements.map((requirement) => (
+                      <TableRow key={requirement.id}>
+                        <TableCell>
+                          {requirement.name}
+                          <Typography variant="body2" color="text.secondary">
+                            {requirement.coursesUsed.map((course) => course.inProgress ? `${course.course} (in progress)` : `${course.course} (${course.grade})`).join(", ")}
+                          </Typography>
+                          {requirement.remaining && (
+                            <Typography variant="body2" color="text.secondary">Remaining: {requirement.remaining.join(", ")}</Typography>
+                          )}
+                          {requirement.belowGrade && (
+                            <Typography variant="body2" color="error">
+                              Grade too low: {requirement.belowGrade.map((course) => `${course.course} (${course.grade})`).join(", ")}
+                            </Typography>
+                          )}
+                        </TableCell>
+                        <TableCell sx={{ color: statusColor(requirement.status), textTransform: "capitalize" }}>
+                          {requirement.status.replace("_", " ")}
+                          <Typography variant="body2" color="text.secondary">
+                            {requirement.completed + requirement.inProgress}/{requirement.needed}
+                          </Typography>
+                        </TableCell>
+                      </TableRow>
+                    ))}
+                  </TableBody>
+                </Table>
+              </>
+            )}
+          </CardContent>
+        </Card>
+      ))}
+      {sbcProgress && (
+        <Card sx={{ width: "80%", maxWidth: 600, borderRadius: 1, boxShadow: 3 }}>
+          <Box sx={{ backgroundColor: "#800000", color: "#fff", p: 1, borderRadius: "4px 4px 0 0" }}>
+            <Typography variant="h6" fontWeight="bold">
+              SBC Progress ({sbcProgress.satisfied}/{sbcProgress.categories.length})
+            </Typography>
+          </Box>
+          <CardContent>
+            <Table>
+              <TableBody>
+                {sbcProgress.categories.map((category) => (
+                  <TableRow key={category.id}>
+                    <TableCell>
+                      <b>{category.id}</b>: {category.name}
+                      {category.coursesUsed.length > 0 && (
+                        <Typography variant="body2" color="text.secondary">
+                          {category.coursesUsed.map((course) => course.inProgress ? `${course.course} (in progress)` : `${course.course} (${course.grade})`).join(", ")}
+                        </Typography>
+                      )}
+                      {category.missing && (
+                        <Typography variant="body2" color="text.secondary">
+                          Needs: {category.missing.join(" or ")}
+                          {category.suggestions && ` (open in ${sbcProgress.term}: ${category.suggestions.join(", ")})`}
+                        </Typography>
+                      )}
+                    </TableCell>
+                    <TableCell sx={{ color: statusColor(category.status), textTransform: "capitalize" }}>
+                      {category.status.replace("_", " ")}
+                      <Typography variant="body2" color="text.secondary">
+                        {category.completed + category.inProgress}/{category.needed}
+                      </Typography>
+                    </TableCell>
+                  </TableRow>
+                ))}
+              </TableBody>
+            </Table>
+            {sbcProgress.suggestions.length > 0 && (
+              <>
+                <Typography sx={{ mt: 2, fontWeight: "bold" }}>Open sections that fill gaps</Typography>
+                <Table size="small">
+                  <TableBody>
+                    {sbcProgress.suggestions.map((suggestion) => (
+                      <TableRow key={suggestion.section}>
+                        <TableCell>
+                          {suggestion.section}: {suggestion.title}
+                          <Typography variant="body2" color="text.secondary">{suggestion.instructor}</Typography>
+                        </TableCell>
+                        <TableCell>{suggestion.fills.join(", ")}</TableCell>
+                        <TableCell>{suggestion.seats} seats</TableCell>
+                      </TableRow>
+                    ))}
+                  </TableBody>
+                </Table>
+              </>
+            )}
+          </CardContent>
+        </Card>
+      )}
+      <Card sx={{ width: "80%", maxWidth: 600, borderRadius: 1, boxShadow: 3 }}>
+        <Box sx={{ backgroundColor: "#800000", color: "#fff", p: 1, borderRadius: "4px 4px 0 0" }}>
+          <Typography variant="h6" fontWeight="bold">Required Documents</Typography>
+        </Box>
+        <CardContent>
+          <Table>
+            <TableBody>
+              {requiredDocuments.map((requirement) => (
+                <TableRow key={requirement.name}>
+                  <TableCell>
+                    {requirement.name}
+                    {requirement.deadline && (
+                      <Typography variant="body2" color="text.secondary">Due {requirement.deadline}</Typography>
+                    )}
+                  </TableCell>
+                  <TableCell sx={{ color: requirement.blocking ? "#d32f2f" : "inherit", textTransform: "capitalize" }}>
+                    {requirement.status}
+                    {requirement.reason && `: ${requirement.reason}`}
+                    {requirement.blocking && " (registration hold)"}
+                  </TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        </CardContent>
+      </Card>
       <Card sx={{ width: "80%", maxWidth: 600, borderRadius: 1, boxShadow: 3 }}>
         <Box
           sx={{
@@ -220,19 +461,30 @@ const Records = () => {
         <CardContent>
           <Table>
             <TableBody>
-              {otherRecords.map((record, idx) => (
-                <TableRow key={idx}>
+              {otherRecords.map((record) => (
+                <TableRow key={record.name}>
                   <TableCell>
                     <Button
                       variant="outlined"
-                      onClick={() => downloadRecord(record.slice(0, -4))}
+                      onClick={() => downloadRecord(record.name)}
                       fullWidth
                       sx={{
                         backgroundColor: "white",
                         color: "black",
                         borderColor: "lightgray" }}
                     >
-                      {record.slice(0, -4)}
+                      {record.name}
+                    </Button>
+                  </TableCell>
+                  <TableCell>
+                    {new Date(record.uploaded).toLocaleDateString()}
+                  </TableCell>
+                  <TableCell>
+                    <Button size="small" onClick={() => openVersions(record.name)}>
+                      v{record.version}
+                    </Button>
+                    <Button size="small" color="error" onClick={() => deleteRecord(record.name)}>
+                      Delete
                     </Button>
                   </TableCell>
                 </TableRow>
@@ -263,6 +515,34 @@ const Records = () => {
           </Button>
         </DialogActions>
       </Dialog>
+      <Dialog open={versionsRecord !== null} onClose={() => setVersionsRecord(null)}>
+        <DialogTitle sx={{ backgroundColor: "#800000", color: "white" }}>
+          {versionsRecord} History
+        </DialogTitle>
+        <DialogContent sx={{ mt: 1 }}>
+          <Table>
+            <TableBody>
+              {versions.map((version) => (
+                <TableRow key={version.version}>
+                  <TableCell>v{version.version}</TableCell>
+                  <TableCell>{new Date(version.uploaded).toLocaleString()}</TableCell>
+                  <TableCell>{version.uploader}</TableCell>
+                  <TableCell>
+                    <Button size="small" onClick={() => downloadRecord(versionsRecord, version.version)}>
+                      Download
+                    </Button>
+                  </TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        </DialogContent>
+        <DialogActions>
+          <Button onClick={() => setVersionsRecord(null)} color="primary">
+            Close
+          </Button>
+        </DialogActions>
+      </Dialog>
       <Dialog open={openUploadDialog} onClose={handleUploadDialogClose}>
         <DialogTitle sx={{ backgroundColor: "#800000", color: "white" }}>Upload Record</DialogTitle>
         <DialogContent sx={{ mt: 1 }}>
@@ -334,7 +614,7 @@ const Records = () => {
             Cancel
           </Button>
           <Button
-            onClick={handleUpload}
+            onClick={() => handleUpload()}
             disabled={!file}
             sx={{
               backgroundColor: file ? "#800000" : "white",
@@ -10,6 +10,34 @@ import config from "../../config.js"
 
 const formatter = new Intl.DateTimeFormat('en-US', { hour: 'numeric', minute: '2-digit', hour12: true });
 
+const wallClockToDate = (wallClock) => {
+  const [hours, minutes] = wallClock.split(':').map(Number);
+  return new Date(2024, 11, 1, hours, minutes);
+};
+
+const parseMeetings = (meetings) => {
+  return (meetings || []).map((meeting) => ({
+    ...meeting,
+    timeStart: wallClockToDate(meeting.timeStart),
+    timeEnd: wallClockToDate(meeting.timeEnd),
+    startDate: meeting.startDate || null,
+    endDate: meeting.endDate || null,
+  }));
+};
+
+const meetingsOverlap = (a, b) => {
+  if (!a.days.split("").some((day) => b.days.includes(day))) {
+    return false;
+  }
+  if (a.timeStart >= b.timeEnd || b.timeStart >= a.timeEnd) {
+    return false;
+  }
+  if ((a.startDate && b.endDate && a.startDate > b.endDate) || (b.startDate && a.endDate && b.startDate > a.endDate)) {
+    return false;
+  }
+  return true;
+};
+
 function CartNoRowsOverlay() {
   return (
     <Box
@@ -76,12 +104,7 @@ const Registration = () => {
             if (Array.isArray(item.class)) {
               item.class = item.class.join('/');
             }
-            if (item.timeStart) {
-              item.timeStart = new Date(item.timeStart);
-            }
-            if (item.timeEnd) {
-              item.timeEnd = new Date(item.timeEnd);
-            }
+            item.meetings = parseMeetings(item.meetings);
             return item;
           });
           setCartRows(processedData);
@@ -102,12 +125,53 @@ const Registration = () => {
     fetchCartRows();
   }, [fetchCartRows]);
 
+  const handleWatchSection = async (selectedClass) => {
+    try {
+      const response = await fetch(`${config.serverUrl}/joinWaitlist`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ id: id, section: selectedClass.id }),
+      });
+      if (response.ok) {
+        setConflictClass({
+          conflictMessage: `${selectedClass.class} ${selectedClass.code}-${selectedClass.section} is full. You are now watching it and will be notified if a seat opens.`,
+          conflictHeader: "Watching Section",
+        });
+      } else if (response.status === 409) {
+        const errorData = await response.json();
+        setConflictClass({
+          conflictMessage: errorData.error || "Could not watch this section.",
+          conflictHeader: "Error Watching Section",
+        });
+      } else {
+        console.error('Failed to watch section:', response.statusText);
+        setConflictClass({
+          conflictMessage: "An unexpected error occurred while watching this section.",
+          conflictHeader: "Server Error",
+        });
+      }
+    } catch (error) {
+      console.error('Error watching section:', error);
+      setConflictClass({
+        conflictMessage: "A network error occurred while watching this section.",
+        conflictHeader: "Network Error",
+      });
+    }
+    setDialogOpen(true);
+  };
+
   const handleAddRow = async (cid) => {
     const selectedClass = searchRows.find((row) => row.id === cid);
     if (!selectedClass) {
       console.error("Selected class not found.");
       return;
     }
+    if (selectedClass.size === 0 && !savedCart.current.some((row) => row.id === selectedClass.id)) {
+      await handleWatchSection(selectedClass);
+      return;
+    }
     const duplicateClass = cartRows.find(
       (cartRow) =>
         cartRow.class === selectedClass.class &&
@@ -123,15 +187,8 @@ const Registration = () => {
       return;
     }
     const timeConflict = cartRows.find((cartRow) =>
-      selectedClass.days.split("").some(
-        (day) =>
-          cartRow.days.includes(day) &&
-          ((selectedClass.timeStart >= cartRow.timeStart &&
-            selectedClass.timeStart < cartRow.timeEnd) ||
-            (selectedClass.timeEnd > cartRow.timeStart &&
-              selectedClass.timeEnd <= cartRow.timeEnd) ||
-            (selectedClass.timeStart <= cartRow.timeStart &&
-              selectedClass.timeEnd >= cartRow.timeEnd))
+      selectedClass.meetings.some((meeting) =>
+        cartRow.meetings.some((cartMeeting) => meetingsOverlap(meeting, cartMeeting))
       )
     );
     if (timeConflict) {
@@ -199,10 +256,11 @@ const Registration = () => {
     return rows.map((row) => ({
       id: row.id,
       class: `${row.class} ${row.code}-${row.section}`,
-      time: `${row.days} ${formatter.format(row.timeStart)}-${formatter.format(row.timeEnd)}`,
-      room: row.room,
+      time: row.meetings.map((meeting) => `${meeting.days} ${formatter.format(meeting.timeStart)}-${formatter.format(meeting.timeEnd)}`).join(', '),
+      room: [...new Set(row.meetings.map((meeting) => meeting.room))].join(', '),
       instructor: getInitialAndRest(row.instructor),
       credits: row.credits,
+      seats: `${row.size}/${row.maxSize}`,
     }));
   };
 
@@ -223,12 +281,7 @@ const Registration = () => {
             if (Array.isArray(item.class)) {
               item.class = item.class.join('/');
             }
-            if (item.timeStart) {
-              item.timeStart = new Date(item.timeStart);
-            }
-            if (item.timeEnd) {
-              item.timeEnd = new Date(item.timeEnd);
-            }
+            item.meetings = parseMeetings(item.meetings);
             return item;
           });
           setSearchRows(processedData);
@@ -379,7 +432,7 @@ const Registration = () => {
             <Typography
               variant="subtitle2"
             >
-              Only classes with space will show (Availability may not be accurate)
+              Full classes show 0 seats. Adding one watches it so you are notified when a seat opens
             </Typography>
             <DataGrid
               rows={displayRows(searchRows)}
@@ -389,6 +442,7 @@ const Registration = () => {
                 { field: 'room', headerName: 'Room', flex: 1 },
                 { field: 'instructor', headerName: 'Instructor', flex: 1 },
                 { field: 'credits', headerName: 'Credits', flex: 0.75, type: 'number', align: 'center', headerAlign: 'center' },
+                { field: 'seats', headerName: 'Seats', flex: 0.75, align: 'center', headerAlign: 'center' },
                 {
                   field: 'delete',
                   headerName: '',
@@ -10,22 +10,24 @@ const locales = {
 const localizer = dateFnsLocalizer({ format, parse, startOfWeek, getDay, locales, });
 
 const Schedule = ({ rows }) => {
-    const strToTimes = (row) => {
+    const strToTimes = (meeting) => {
         let arr = [];
-        for (const char of row.days) {
+        for (const char of meeting.days) {
             let days = ['U', 'M', 'T', 'W', 'R', 'F', 'S'];
             let day = days.indexOf(char) + 1;
-            arr.push([new Date(2024, 11, day, row.timeStart.getHours(), row.timeStart.getMinutes()), new Date(2024, 11, day, row.timeEnd.getHours(), row.timeEnd.getMinutes())]);
+            arr.push([new Date(2024, 11, day, meeting.timeStart.getHours(), meeting.timeStart.getMinutes()), new Date(2024, 11, day, meeting.timeEnd.getHours(), meeting.timeEnd.getMinutes())]);
         }
         return arr;
     };
 
     const events = rows.flatMap((row) => (
-        strToTimes(row).map((days) => ({
-            title: row.class + ' ' + row.code + '-' + row.section,
-            start: days[0],
-            end: days[1]
-        }))
+        row.meetings.flatMap((meeting) => (
+            strToTimes(meeting).map((days) => ({
+                title: row.class + ' ' + row.code + '-' + row.section + ' ' + meeting.room,
+                start: days[0],
+                end: days[1]
+            }))
+        ))
     ));
 
     return (
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type periodTransition struct {
+	From     string    `bson:"from" json:"from"`
+	To       string    `bson:"to" json:"to"`
+	By       string    `bson:"by" json:"by"`
+	At       time.Time `bson:"at" json:"at"`
+	Comments string    `bson:"comments,omitempty" json:"comments,omitempty"`
+}
+
+type timesheetPeriod struct {
+	Id       string             `bson:"id" json:"id"`
+	Start    string             `bson:"start" json:"start"`
+	End      string             `bson:"end" json:"end"`
+	Status   string             `bson:"status" json:"status"`
+	Comments string             `bson:"comments,omitempty" json:"comments,omitempty"`
+	History  []periodTransition `bson:"history" json:"history"`
+}
+
+var (
+	errInvalidPeriod     = errors.New("invalid pay period")
+	errInvalidTransition = errors.New("invalid timesheet transition")
+	errNotSupervisor     = errors.New("not the student's supervisor")
+	entryStatuses        = map[string]string{
+		"draft":     "",
+		"submitted": "S",
+		"approved":  "A",
+		"rejected":  "R",
+		"late":      "L",
+	}
+)
+
+func handleSubmitTimesheet(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id    string `json:"id"`
+		Start string `json:"start"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	err = transitionPeriod(request.Id, request.Start, []string{"draft", "rejected"}, "submitted", request.Id, "")
+	sendTransitionResult(w, err)
+}
+
+func handleReviewTimesheet(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		SupervisorId string `json:"supervisorId"`
+		Id           string `json:"id"`
+		Start        string `json:"start"`
+		Status       string `json:"status"`
+		Comments     string `json:"comments"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.SupervisorId, "supervisor") {
+		return
+	}
+	if request.Status != "approved" && request.Status != "rejected" {
+		http.Error(w, "Status must be approved or rejected", http.StatusBadRequest)
+		return
+	}
+	if request.Status == "rejected" && request.Comments == "" {
+		http.Error(w, "Rejections need comments", http.StatusBadRequest)
+		return
+	}
+	supervised, err := supervises(request.SupervisorId, request.Id)
+	if err != nil || !supervised {
+		sendTransitionResult(w, errNotSupervisor)
+		return
+	}
+	err = transitionPeriod(request.Id, request.Start, []string{"submitted"}, request.Status, request.SupervisorId, request.Comments)
+	if err == nil {
+		message := fmt.Sprintf("Your timesheet for the pay period starting %s was %s.", request.Start, request.Status)
+		if request.Comments != "" {
+			message += " Comments: " + request.Comments
+		}
+		go notifyUser(request.Id, "Timesheet "+request.Status, message, "")
+	}
+	sendTransitionResult(w, err)
+}
+
+func handleReviewLateEntry(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		SupervisorId string `json:"supervisorId"`
+		EntryId      string `json:"entryId"`
+		Status       string `json:"status"`
+		Comments     string `json:"comments"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.SupervisorId, "supervisor") {
+		return
+	}
+	if request.Status != "approved" && request.Status != "rejected" {
+		http.Error(w, "Status must be approved or rejected", http.StatusBadRequest)
+		return
+	}
+	entryID, err := primitive.ObjectIDFromHex(request.EntryId)
+	if err != nil {
+		http.Error(w, "Invalid entryId", http.StatusBadRequest)
+		return
+	}
+	entry, err := reviewLateEntry(request.SupervisorId, entryID, request.Status)
+	if errors.Is(err, errNoEntry) {
+		http.Error(w, "No late entry to review", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		message := fmt.Sprintf("Your late timesheet entry for %s was %s.", entry.TimeIn.In(campusLocation).Format("Jan 2 3:04 PM"), request.Status)
+		if request.Comments != "" {
+			message += " Comments: " + request.Comments
+		}
+		go notifyUser(entry.Id, "Timesheet entry "+request.Status, message, "")
+	}
+	sendTransitionResult(w, err)
+}
+
+func handleGetPendingTimesheets(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		SupervisorId string `json:"supervisorId"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.SupervisorId, "supervisor") {
+		return
+	}
+	pending, err := getPendingTimesheets(request.SupervisorId)
+	if err != nil {
+		http.Error(w, "Error with getting pending timesheets", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(pending)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleGetTimesheetPeriods(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	periods, err := getTimesheetPeriods(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting timesheet periods", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(periods)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func sendTransitionResult(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidPeriod):
+		http.Error(w, "Start must be the first day of a pay period", http.StatusBadRequest)
+	case errors.Is(err, errNotSupervisor):
+		http.Error(w, "Only the student's supervisor can review this timesheet", http.StatusForbidden)
+	case errors.Is(err, errInvalidTransition):
+		sendConflict(w, err.Error())
+	case err != nil:
+		http.Error(w, "Error updating timesheet", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func getPeriodStatus(id string, start string) (string, error) {
+	collection := dbClient.Database(dbName).Collection("timesheetPeriods")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var period timesheetPeriod
+	err := collection.FindOne(ctx, bson.M{"id": id, "start": start}).Decode(&period)
+	if err == mongo.ErrNoDocuments {
+		return "draft", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch timesheet period: %v", err)
+	}
+	return period.Status, nil
+}
+
+func transitionPeriod(id string, start string, from []string, to string, by string, comments string) error {
+	bounds, err := periodBounds(start)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	timesheets := dbClient.Database(dbName).Collection("timesheets")
+	periods := dbClient.Database(dbName).Collection("timesheetPeriods")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		current := "draft"
+		var period timesheetPeriod
+		err := periods.FindOne(sc, bson.M{"id": id, "start": start}).Decode(&period)
+		if err == nil {
+			current = period.Status
+		} else if err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch timesheet period: %v", err)
+		}
+		allowed := false
+		for _, status := range from {
+			if status == current {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%w: timesheet for %s is %s", errInvalidTransition, start, current)
+		}
+		if to == "submitted" && !periodEditable(bounds, current, now) {
+			return nil, fmt.Errorf("%w: the submission deadline for %s has passed", errInvalidTransition, start)
+		}
+		var entryFrom []string
+		for _, status := range from {
+			entryFrom = append(entryFrom, entryStatuses[status])
+		}
+		inPeriod := bson.M{"id": id, "timeIn": bson.M{"$gte": bounds.Start, "$lt": bounds.End}}
+		if to == "submitted" {
+			count, err := timesheets.CountDocuments(sc, inPeriod)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch timesheet: %v", err)
+			}
+			if count == 0 {
+				return nil, fmt.Errorf("%w: no entries for the pay period starting %s", errInvalidTransition, start)
+			}
+		}
+		_, err = timesheets.UpdateMany(sc, bson.M{"$and": bson.A{inPeriod, bson.M{"status": bson.M{"$in": entryFrom}}}}, bson.M{
+			"$set": bson.M{"status": entryStatuses[to], "updated": time.Now()},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update timesheet entries: %v", err)
+		}
+		transition := periodTransition{From: current, To: to, By: by, At: now, Comments: comments}
+		update := bson.M{
+			"$set": bson.M{
+				"end":      bounds.lastDay(),
+				"status":   to,
+				"comments": comments,
+			},
+			"$push": bson.M{"history": transition},
+		}
+		_, err = periods.UpdateOne(sc, bson.M{"id": id, "start": start}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, fmt.Errorf("failed to update timesheet period: %v", err)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// reviewLateEntry settles an entry that was recorded after its pay period
+// locked. Approved entries are picked up by the period's next payroll batch.
+func reviewLateEntry(supervisor string, entryID primitive.ObjectID, status string) (shift, error) {
+	collection := dbClient.Database(dbName).Collection("timesheets")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var entry shift
+	err := collection.FindOne(ctx, bson.M{"_id": entryID, "status": entryStatuses["late"]}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return shift{}, errNoEntry
+	}
+	if err != nil {
+		return shift{}, fmt.Errorf("failed to fetch timesheet entry: %v", err)
+	}
+	supervised, err := supervises(supervisor, entry.Id)
+	if err != nil {
+		return shift{}, err
+	}
+	if !supervised {
+		return shift{}, errNotSupervisor
+	}
+	update := bson.M{
+		"$set": bson.M{"status": entryStatuses[status], "updated": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": entryID, "status": entryStatuses["late"]}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return shift{}, errNoEntry
+	}
+	if err != nil {
+		return shift{}, fmt.Errorf("failed to update timesheet entry: %v", err)
+	}
+	return entry, nil
+}
+
+func getTimesheetPeriods(id string) ([]timesheetPeriod, error) {
+	collection := dbClient.Database(dbName).Collection("timesheetPeriods")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"start": -1})
+	cursor, err := collection.Find(ctx, bson.M{"id": id}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch timesheet periods: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []timesheetPeriod{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func getPendingTimesheets(supervisor string) ([]map[string]interface{}, error) {
+	users := dbClient.Database(dbName).Collection("users")
+	periods := dbClient.Database(dbName).Collection("timesheetPeriods")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := supervisedStudents(supervisor)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := users.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch students: %v", err)
+	}
+	var students []struct {
+		Id    string `bson:"id"`
+		First string `bson:"first"`
+		Last  string `bson:"last"`
+	}
+	if err = cursor.All(ctx, &students); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	pending := []map[string]interface{}{}
+	for _, student := range students {
+		timesheet, err := getTimesheet(student.Id)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := periods.Find(ctx, bson.M{"id": student.Id, "status": "submitted"}, options.Find().SetSort(bson.M{"start": 1}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch timesheet periods: %v", err)
+		}
+		var submitted []timesheetPeriod
+		if err = cursor.All(ctx, &submitted); err != nil {
+			return nil, fmt.Errorf("failed to decode results: %v", err)
+		}
+		for _, period := range submitted {
+			bounds, err := periodBounds(period.Start)
+			if err != nil {
+				continue
+			}
+			entries := []shift{}
+			var hours float64
+			for _, entry := range timesheet {
+				if !entry.TimeIn.Before(bounds.Start) && entry.TimeIn.Before(bounds.End) {
+					entries = append(entries, entry)
+					hours += entry.TimeOut.Sub(entry.TimeIn).Hours()
+				}
+			}
+			pending = append(pending, map[string]interface{}{
+				"id":      student.Id,
+				"name":    student.First + " " + student.Last,
+				"start":   period.Start,
+				"end":     period.End,
+				"entries": entries,
+				"hours":   hours,
+			})
+		}
+		late := []shift{}
+		var lateHours float64
+		for _, entry := range timesheet {
+			if entry.Status == entryStatuses["late"] {
+				late = append(late, entry)
+				lateHours += entry.TimeOut.Sub(entry.TimeIn).Hours()
+			}
+		}
+		if len(late) > 0 {
+			pending = append(pending, map[string]interface{}{
+				"id":      student.Id,
+				"name":    student.First + " " + student.Last,
+				"late":    true,
+				"entries": late,
+				"hours":   lateHours,
+			})
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type requirement struct {
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Courses  []string `json:"courses"`
+	Count    int      `json:"count"`
+	Credits  float64  `json:"credits"`
+	Subjects []string `json:"subjects"`
+	MinLevel int      `json:"minLevel"`
+	Exclude  []string `json:"exclude"`
+	MinGrade string   `json:"minGrade"`
+	SBC      string   `json:"sbc"`
+}
+
+type majorRequirements struct {
+	Name         string        `json:"name"`
+	TotalCredits float64       `json:"totalCredits"`
+	Requirements []requirement `json:"requirements"`
+}
+
+type requirementsFile struct {
+	DefaultCredits     float64                      `json:"defaultCredits"`
+	UpperDivisionLevel int                          `json:"upperDivisionLevel"`
+	SBC                sbcRules                     `json:"sbc"`
+	Majors             map[string]majorRequirements `json:"majors"`
+}
+
+type courseRecord struct {
+	Course     string  `json:"course"`
+	Grade      string  `json:"grade,omitempty"`
+	Credits    float64 `json:"credits"`
+	InProgress bool    `json:"inProgress"`
+	aliases    []string
+	level      int
+	sbc        []string
+}
+
+type requirementAudit struct {
+	Id          string         `json:"id"`
+	Name        string         `json:"name"`
+	Type        string         `json:"type"`
+	Status      string         `json:"status"`
+	Needed      float64        `json:"needed"`
+	Completed   float64        `json:"completed"`
+	InProgress  float64        `json:"inProgress"`
+	CoursesUsed []courseRecord `json:"coursesUsed"`
+	Remaining   []string       `json:"remaining,omitempty"`
+	BelowGrade  []courseRecord `json:"belowGrade,omitempty"`
+}
+
+type majorAudit struct {
+	Major             string             `json:"major"`
+	Name              string             `json:"name"`
+	Status            string             `json:"status"`
+	TotalCredits      float64            `json:"totalCredits"`
+	EarnedCredits     float64            `json:"earnedCredits"`
+	InProgressCredits float64            `json:"inProgressCredits"`
+	Requirements      []requirementAudit `json:"requirements"`
+}
+
+var (
+	degreeRequirements requirementsFile
+	letterGrades       = []string{"A", "A-", "B+", "B", "B-", "C+", "C", "C-", "D+", "D", "F"}
+	requirementTypes   = map[string]bool{"courses": true, "choose": true, "credits": true, "sbc": true, "upper": true}
+)
+
+func loadRequirements(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open requirements file: %v", err)
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&degreeRequirements)
+	if err != nil {
+		return fmt.Errorf("failed to parse requirements file: %v", err)
+	}
+	if degreeRequirements.DefaultCredits <= 0 {
+		degreeRequirements.DefaultCredits = 3
+	}
+	if degreeRequirements.UpperDivisionLevel <= 0 {
+		degreeRequirements.UpperDivisionLevel = 300
+	}
+	err = validateSBC(degreeRequirements.SBC)
+	if err != nil {
+		return err
+	}
+	for major, program := range degreeRequirements.Majors {
+		seen := make(map[string]bool)
+		for _, req := range program.Requirements {
+			if req.Id == "" || seen[req.Id] {
+				return fmt.Errorf("major %s has a requirement with a missing or duplicate id", major)
+			}
+			seen[req.Id] = true
+			if !requirementTypes[req.Type] {
+				return fmt.Errorf("requirement %s has unknown type %s", req.Id, req.Type)
+			}
+			if req.MinGrade != "" && indexInArray(req.MinGrade, letterGrades) < 0 {
+				return fmt.Errorf("requirement %s has unknown minimum grade %s", req.Id, req.MinGrade)
+			}
+			switch {
+			case req.Type == "courses" && len(req.Courses) == 0,
+				req.Type == "choose" && (req.Count <= 0 || req.Count > len(req.Courses)),
+				req.Type == "credits" && req.Credits <= 0,
+				req.Type == "sbc" && (req.SBC == "" || req.Count <= 0),
+				req.Type == "upper" && req.Credits <= 0:
+				return fmt.Errorf("requirement %s is incomplete for type %s", req.Id, req.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func handleGetDegreeAudit(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	audits, err := degreeAudit(request.Id)
+	if err != nil {
+		http.Error(w, "Error with running degree audit", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(audits)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func courseLevel(code string) int {
+	digits := code
+	for i, c := range code {
+		if c < '0' || c > '9' {
+			digits = code[:i]
+			break
+		}
+	}
+	level, _ := strconv.Atoi(digits)
+	return level
+}
+
+func stringList(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case bson.A:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+	case string:
+		if v != "" {
+			values = strings.Split(v, "/")
+		}
+	}
+	return values
+}
+
+func catalogRecord(course bson.M) courseRecord {
+	classes := stringList(course["class"])
+	code := fmt.Sprint(course["code"])
+	record := courseRecord{Credits: degreeRequirements.DefaultCredits, level: courseLevel(code), sbc: stringList(course["sbc"])}
+	for _, class := range classes {
+		record.aliases = append(record.aliases, class+" "+code)
+	}
+	if len(record.aliases) > 0 {
+		record.Course = record.aliases[0]
+	}
+	if credits, ok := numberValue(course["credits"]); ok {
+		record.Credits = credits
+	}
+	return record
+}
+
+func getCatalog() (map[string]courseRecord, error) {
+	collection := dbClient.Database(dbName).Collection("courses")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch courses: %v", err)
+	}
+	var courses []bson.M
+	if err = cursor.All(ctx, &courses); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	catalog := make(map[string]courseRecord)
+	for _, course := range courses {
+		record := catalogRecord(course)
+		for _, alias := range record.aliases {
+			catalog[alias] = record
+		}
+	}
+	return catalog, nil
+}
+
+func studentRecords(id string, catalog map[string]courseRecord) ([]courseRecord, float64, []string, error) {
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var user struct {
+		Major   string            `bson:"major"`
+		Credits float64           `bson:"credits"`
+		Classes map[string]string `bson:"classes"`
+		Current []bson.M          `bson:"current"`
+	}
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&user)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to fetch user with id %s: %v", id, err)
+	}
+	var records []courseRecord
+	for course, grade := range user.Classes {
+		record, ok := catalog[course]
+		if !ok {
+			parts := strings.SplitN(course, " ", 2)
+			record = courseRecord{Course: course, Credits: degreeRequirements.DefaultCredits, aliases: []string{course}}
+			if len(parts) == 2 {
+				record.level = courseLevel(parts[1])
+			}
+		}
+		record.Course = course
+		record.Grade = grade
+		records = append(records, record)
+	}
+	for _, section := range user.Current {
+		course, ok := section["course"].(bson.M)
+		if !ok {
+			continue
+		}
+		record := catalogRecord(course)
+		record.InProgress = true
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].InProgress != records[j].InProgress {
+			return !records[i].InProgress
+		}
+		return records[i].Course < records[j].Course
+	})
+	var majors []string
+	for _, major := range strings.Split(user.Major, "/") {
+		if major != "" {
+			majors = append(majors, major)
+		}
+	}
+	return records, user.Credits, majors, nil
+}
+
+func meetsGrade(record courseRecord, minGrade string) bool {
+	if record.InProgress {
+		return true
+	}
+	grade := indexInArray(record.Grade, letterGrades)
+	if grade < 0 || record.Grade == "F" {
+		return false
+	}
+	if minGrade == "" {
+		return true
+	}
+	return grade <= indexInArray(minGrade, letterGrades)
+}
+
+func recordMatches(record courseRecord, courses []string) bool {
+	for _, alias := range record.aliases {
+		for _, course := range courses {
+			if alias == course {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sbcMatches(record courseRecord, category string) bool {
+	for _, sbc := range record.sbc {
+		if sbc == category {
+			return true
+		}
+	}
+	return false
+}
+
+func inPool(record courseRecord, req requirement) bool {
+	if recordMatches(record, req.Exclude) {
+		return false
+	}
+	if record.level < req.MinLevel {
+		return false
+	}
+	if len(req.Subjects) == 0 {
+		return true
+	}
+	for _, alias := range record.aliases {
+		for _, subject := range req.Subjects {
+			if strings.HasPrefix(alias, subject+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// auditMajor applies records to requirements in order. Course, choose and
+// credit requirements each consume the courses they use; SBC and
+// upper-division requirements are overlays that may count any course again.
+func auditMajor(major string, program majorRequirements, records []courseRecord, earned float64) majorAudit {
+	used := make([]bool, len(records))
+	result := majorAudit{Major: major, Name: program.Name, TotalCredits: program.TotalCredits, EarnedCredits: earned}
+	for _, record := range records {
+		if record.InProgress {
+			result.InProgressCredits += record.Credits
+		}
+	}
+	for _, req := range program.Requirements {
+		audit := requirementAudit{Id: req.Id, Name: req.Name, Type: req.Type, CoursesUsed: []courseRecord{}}
+		take := func(i int, amount float64) {
+			audit.CoursesUsed = append(audit.CoursesUsed, records[i])
+			if records[i].InProgress {
+				audit.InProgress += amount
+			} else {
+				audit.Completed += amount
+			}
+		}
+		var belowGrade = make(map[string]bool)
+		eligible := func(i int, exclusive bool) bool {
+			if exclusive && used[i] {
+				return false
+			}
+			if !meetsGrade(records[i], req.MinGrade) {
+				if records[i].Grade != "" {
+					belowGrade[records[i].Course] = true
+				}
+				return false
+			}
+			return true
+		}
+		switch req.Type {
+		case "courses", "choose":
+			audit.Needed = float64(len(req.Courses))
+			if req.Type == "choose" {
+				audit.Needed = float64(req.Count)
+			}
+			var remaining []string
+			for _, course := range req.Courses {
+				if audit.Completed+audit.InProgress >= audit.Needed {
+					break
+				}
+				found := false
+				for i := range records {
+					if recordMatches(records[i], []string{course}) && eligible(i, true) {
+						used[i] = true
+						take(i, 1)
+						found = true
+						break
+					}
+				}
+				if !found {
+					remaining = append(remaining, course)
+				}
+			}
+			if audit.Completed+audit.InProgress < audit.Needed {
+				audit.Remaining = remaining
+			}
+		case "credits":
+			audit.Needed = req.Credits
+			for i := range records {
+				if audit.Completed+audit.InProgress >= audit.Needed {
+					break
+				}
+				if inPool(records[i], req) && eligible(i, true) {
+					used[i] = true
+					take(i, records[i].Credits)
+				}
+			}
+		case "sbc":
+			audit.Needed = float64(req.Count)
+			for i := range records {
+				if audit.Completed+audit.InProgress >= audit.Needed {
+					break
+				}
+				if sbcMatches(records[i], req.SBC) && eligible(i, false) {
+					take(i, 1)
+				}
+			}
+		case "upper":
+			audit.Needed = req.Credits
+			for i := range records {
+				if records[i].level >= degreeRequirements.UpperDivisionLevel && eligible(i, false) {
+					take(i, records[i].Credits)
+				}
+			}
+		}
+		for _, record := range records {
+			if belowGrade[record.Course] && !record.InProgress {
+				audit.BelowGrade = append(audit.BelowGrade, record)
+			}
+		}
+		audit.Status = requirementStatus(audit.Completed, audit.InProgress, audit.Needed)
+		result.Requirements = append(result.Requirements, audit)
+	}
+	result.Status = requirementStatus(earned, result.InProgressCredits, program.TotalCredits)
+	for _, audit := range result.Requirements {
+		if audit.Status == "outstanding" || (audit.Status == "in_progress" && result.Status == "satisfied") {
+			result.Status = audit.Status
+		}
+	}
+	return result
+}
+
+func requirementStatus(completed, inProgress, needed float64) string {
+	switch {
+	case completed >= needed:
+		return "satisfied"
+	case completed+inProgress >= needed:
+		return "in_progress"
+	default:
+		return "outstanding"
+	}
+}
+
+func degreeAudit(id string) ([]majorAudit, error) {
+	catalog, err := getCatalog()
+	if err != nil {
+		return nil, err
+	}
+	records, earned, majors, err := studentRecords(id, catalog)
+	if err != nil {
+		return nil, err
+	}
+	audits := []majorAudit{}
+	for _, major := range majors {
+		program, ok := degreeRequirements.Majors[major]
+		if !ok {
+			audits = append(audits, majorAudit{Major: major, Status: "unavailable", Requirements: []requirementAudit{}})
+			continue
+		}
+		audits = append(audits, auditMajor(major, program, records, earned))
+	}
+	return audits, nil
+}
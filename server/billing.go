@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ledgerEntry struct {
+	EntryId     primitive.ObjectID `bson:"_id,omitempty" json:"entryId"`
+	Id          string             `bson:"id" json:"id"`
+	Term        string             `bson:"term" json:"term"`
+	Kind        string             `bson:"kind" json:"kind"`
+	Category    string             `bson:"category" json:"category"`
+	Source      string             `bson:"source,omitempty" json:"source,omitempty"`
+	Description string             `bson:"description" json:"description"`
+	Amount      int64              `bson:"amount" json:"amountCents"`
+	Posted      time.Time          `bson:"posted" json:"posted"`
+	PostedBy    string             `bson:"postedBy,omitempty" json:"postedBy,omitempty"`
+}
+
+type termCharge struct {
+	Source      string
+	Category    string
+	Description string
+	Amount      int64
+}
+
+type statement struct {
+	Id             string        `json:"id"`
+	Term           string        `json:"term"`
+	Residency      string        `json:"residency"`
+	Credits        float64       `json:"credits"`
+	Entries        []ledgerEntry `json:"entries"`
+	Charges        int64         `json:"chargesCents"`
+	Payments       int64         `json:"paymentsCents"`
+	Balance        int64         `json:"balanceCents"`
+	AccountBalance int64         `json:"accountBalanceCents"`
+}
+
+var ledgerKinds = map[string]bool{"charge": true, "payment": true, "refund": true, "adjustment": true}
+
+var errInvalidLedgerEntry = errors.New("invalid ledger entry")
+
+func handleGetStatement(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id   string `json:"id"`
+		Term string `json:"term"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if request.Term == "" {
+		request.Term = appConfig.Term.Name
+	}
+	result, err := getStatement(request.Id, request.Term)
+	if err != nil {
+		http.Error(w, "Error with getting statement", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleGetStatementTerms(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	terms, err := getStatementTerms(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting statements", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(terms)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handlePostLedgerEntry(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId     string `json:"adminId"`
+		Id          string `json:"id"`
+		Term        string `json:"term"`
+		Kind        string `json:"kind"`
+		Amount      int64  `json:"amountCents"`
+		Description string `json:"description"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	if request.Term == "" {
+		request.Term = appConfig.Term.Name
+	}
+	entry := ledgerEntry{
+		Id:          request.Id,
+		Term:        request.Term,
+		Kind:        request.Kind,
+		Category:    "manual",
+		Description: request.Description,
+		Amount:      request.Amount,
+		PostedBy:    request.AdminId,
+	}
+	entry, err = postManualEntry(entry)
+	if errors.Is(err, errInvalidLedgerEntry) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error posting ledger entry", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func ensureBillingIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("ledger").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "id", Value: 1}, {Key: "term", Value: 1}, {Key: "posted", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create ledger index: %v", err)
+	}
+	_, err = db.Collection("billingAccounts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create billing accounts index: %v", err)
+	}
+}
+
+func validateBilling(billing billingConfig) error {
+	if _, ok := billing.Tuition[billing.DefaultResidency]; !ok {
+		return fmt.Errorf("billing defaultResidency %s has no tuition schedule", billing.DefaultResidency)
+	}
+	for residency, bands := range billing.Tuition {
+		if len(bands) == 0 || bands[0].MinCredits != 0 {
+			return fmt.Errorf("tuition schedule %s must start at 0 credits", residency)
+		}
+		for i := 1; i < len(bands); i++ {
+			if bands[i].MinCredits <= bands[i-1].MinCredits {
+				return fmt.Errorf("tuition schedule %s bands must be in increasing credit order", residency)
+			}
+		}
+	}
+	return nil
+}
+
+func toCents(dollars float64) int64 {
+	return int64(math.Round(dollars * 100))
+}
+
+func tuitionFor(credits float64, bands []tuitionBand) float64 {
+	var band tuitionBand
+	for _, b := range bands {
+		if credits >= b.MinCredits {
+			band = b
+		}
+	}
+	return band.Flat + band.PerCredit*math.Max(0, credits-band.IncludedCredits)
+}
+
+func residencyFor(id string) (string, error) {
+	residency, err := getResidency(id)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := appConfig.Billing.Tuition[residency]; !ok {
+		residency = appConfig.Billing.DefaultResidency
+	}
+	return residency, nil
+}
+
+func courseFee(course bson.M) (string, float64) {
+	name := fmt.Sprintf("%s %v", joinClass(course["class"]), course["code"])
+	if fee, ok := appConfig.Billing.CourseFees[name]; ok {
+		return name, fee
+	}
+	if classes, ok := course["class"].(bson.A); ok {
+		for _, class := range classes {
+			alias := fmt.Sprintf("%v %v", class, course["code"])
+			if fee, ok := appConfig.Billing.CourseFees[alias]; ok {
+				return alias, fee
+			}
+		}
+	}
+	return name, 0
+}
+
+func termCharges(id string) ([]termCharge, float64, error) {
+	residency, err := residencyFor(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	current, err := getCurrent(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	credits := 0.0
+	var charges []termCharge
+	charged := make(map[string]bool)
+	for _, section := range current {
+		course, ok := section["course"].(bson.M)
+		if !ok {
+			continue
+		}
+		if value, ok := course["credits"].(float64); ok {
+			credits += value
+		}
+		name, fee := courseFee(course)
+		if fee == 0 || charged[name] {
+			continue
+		}
+		charged[name] = true
+		charges = append(charges, termCharge{
+			Source:      "course_fee:" + name,
+			Category:    "course_fee",
+			Description: name + " course fee",
+			Amount:      toCents(fee),
+		})
+	}
+	tuition := tuitionFor(credits, appConfig.Billing.Tuition[residency])
+	charges = append(charges, termCharge{
+		Source:      "tuition",
+		Category:    "tuition",
+		Description: fmt.Sprintf("Tuition, %g credits (%s)", credits, residency),
+		Amount:      toCents(tuition),
+	})
+	assigned, err := getAssignedBed(id)
+	if err != nil && err != errNoBed {
+		return nil, 0, err
+	}
+	if err == nil {
+		charges = append(charges, termCharge{
+			Source:      "housing",
+			Category:    "housing",
+			Description: fmt.Sprintf("Housing, %s %s (%s)", assigned.Building, assigned.Room, assigned.RoomType),
+			Amount:      toCents(assigned.Rate),
+		})
+	}
+	return charges, credits, nil
+}
+
+func postLedgerEntries(sc mongo.SessionContext, id string, entries []ledgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	db := dbClient.Database(dbName)
+	var documents []interface{}
+	var total int64
+	for _, entry := range entries {
+		documents = append(documents, entry)
+		total += entry.Amount
+	}
+	_, err := db.Collection("ledger").InsertMany(sc, documents)
+	if err != nil {
+		return fmt.Errorf("failed to post ledger entries: %v", err)
+	}
+	_, err = db.Collection("billingAccounts").UpdateOne(sc, bson.M{"id": id},
+		bson.M{"$inc": bson.M{"balance": total}, "$set": bson.M{"updated": time.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to update account balance: %v", err)
+	}
+	return nil
+}
+
+func withLedger(fn func(sc mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func recomputeCharges(id string) error {
+	desired, _, err := termCharges(id)
+	if err != nil {
+		return err
+	}
+	term := appConfig.Term.Name
+	now := time.Now()
+	return withLedger(func(sc mongo.SessionContext) error {
+		cursor, err := dbClient.Database(dbName).Collection("ledger").Find(sc,
+			bson.M{"id": id, "term": term, "kind": "charge", "source": bson.M{"$exists": true}},
+			options.Find().SetSort(bson.M{"posted": 1}))
+		if err != nil {
+			return fmt.Errorf("failed to fetch ledger: %v", err)
+		}
+		var posted []ledgerEntry
+		if err = cursor.All(sc, &posted); err != nil {
+			return fmt.Errorf("failed to decode results: %v", err)
+		}
+		totals := make(map[string]int64)
+		previous := make(map[string]ledgerEntry)
+		for _, entry := range posted {
+			totals[entry.Source] += entry.Amount
+			previous[entry.Source] = entry
+		}
+		var entries []ledgerEntry
+		seen := make(map[string]bool)
+		for _, charge := range desired {
+			seen[charge.Source] = true
+			delta := charge.Amount - totals[charge.Source]
+			if delta == 0 {
+				continue
+			}
+			description := charge.Description
+			if _, ok := previous[charge.Source]; ok {
+				description += " (adjusted)"
+			}
+			entries = append(entries, ledgerEntry{Id: id, Term: term, Kind: "charge", Category: charge.Category,
+				Source: charge.Source, Description: description, Amount: delta, Posted: now})
+		}
+		var removed []string
+		for source := range totals {
+			if !seen[source] && totals[source] != 0 {
+				removed = append(removed, source)
+			}
+		}
+		sort.Strings(removed)
+		for _, source := range removed {
+			last := previous[source]
+			description := strings.TrimSuffix(last.Description, " (adjusted)") + " (removed)"
+			entries = append(entries, ledgerEntry{Id: id, Term: term, Kind: "charge", Category: last.Category,
+				Source: source, Description: description, Amount: -totals[source], Posted: now})
+		}
+		return postLedgerEntries(sc, id, entries)
+	})
+}
+
+func refreshCharges(id string) {
+	err := recomputeCharges(id)
+	if err != nil {
+		log.Printf("Error recomputing charges for %s: %v", id, err)
+	}
+}
+
+func postManualEntry(entry ledgerEntry) (ledgerEntry, error) {
+	if !ledgerKinds[entry.Kind] {
+		return ledgerEntry{}, fmt.Errorf("%w: kind must be charge, payment, refund or adjustment", errInvalidLedgerEntry)
+	}
+	if entry.Amount == 0 || entry.Description == "" {
+		return ledgerEntry{}, fmt.Errorf("%w: amount and description are required", errInvalidLedgerEntry)
+	}
+	if entry.Kind == "charge" && entry.Amount < 0 {
+		return ledgerEntry{}, fmt.Errorf("%w: use an adjustment to credit an account", errInvalidLedgerEntry)
+	}
+	switch entry.Kind {
+	case "payment":
+		entry.Amount = -abs64(entry.Amount)
+	case "refund":
+		entry.Amount = abs64(entry.Amount)
+	}
+	entry.Posted = time.Now()
+	err := withLedger(func(sc mongo.SessionContext) error {
+		return postLedgerEntries(sc, entry.Id, []ledgerEntry{entry})
+	})
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	return entry, nil
+}
+
+func abs64(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func getAccountBalance(id string) (int64, error) {
+	collection := dbClient.Database(dbName).Collection("billingAccounts")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var account struct {
+		Balance int64 `bson:"balance"`
+	}
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch account: %v", err)
+	}
+	return account.Balance, nil
+}
+
+func getStatement(id string, term string) (statement, error) {
+	collection := dbClient.Database(dbName).Collection("ledger")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := statement{Id: id, Term: term, Entries: []ledgerEntry{}}
+	var err error
+	result.Residency, err = residencyFor(id)
+	if err != nil {
+		return statement{}, err
+	}
+	if term == appConfig.Term.Name {
+		_, result.Credits, err = termCharges(id)
+		if err != nil {
+			return statement{}, err
+		}
+	}
+	cursor, err := collection.Find(ctx, bson.M{"id": id, "term": term}, options.Find().SetSort(bson.M{"posted": 1}))
+	if err != nil {
+		return statement{}, fmt.Errorf("failed to fetch ledger: %v", err)
+	}
+	if err = cursor.All(ctx, &result.Entries); err != nil {
+		return statement{}, fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, entry := range result.Entries {
+		switch entry.Kind {
+		case "payment", "refund":
+			result.Payments -= entry.Amount
+		default:
+			result.Charges += entry.Amount
+		}
+	}
+	result.Balance = result.Charges - result.Payments
+	result.AccountBalance, err = getAccountBalance(id)
+	if err != nil {
+		return statement{}, err
+	}
+	return result, nil
+}
+
+func getStatementTerms(id string) ([]string, error) {
+	collection := dbClient.Database(dbName).Collection("ledger")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	values, err := collection.Distinct(ctx, "term", bson.M{"id": id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch statement terms: %v", err)
+	}
+	terms := []string{appConfig.Term.Name}
+	for _, value := range values {
+		if term, ok := value.(string); ok && term != appConfig.Term.Name {
+			terms = append(terms, term)
+		}
+	}
+	return terms, nil
+}
@@ -0,0 +1,36 @@
+//go:build minio
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// Run against a local MinIO with:
+//
+//	minio server /tmp/minio
+//	go test -tags minio -run S3 .
+func TestS3BlobStore(t *testing.T) {
+	config := storageConfig{
+		Backend: "s3",
+		S3: s3Config{
+			Endpoint:  envOr("MINIO_ENDPOINT", "http://localhost:9000"),
+			Bucket:    envOr("MINIO_BUCKET", "polar-records-test"),
+			AccessKey: envOr("MINIO_ACCESS_KEY", "minioadmin"),
+			SecretKey: envOr("MINIO_SECRET_KEY", "minioadmin"),
+		},
+	}
+	store, err := newBlobStore(config)
+	if err != nil {
+		t.Fatalf("newBlobStore: %v", err)
+	}
+	exerciseBlobStore(t, store)
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
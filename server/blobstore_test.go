@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func exerciseBlobStore(t *testing.T, store blobStore) {
+	ctx := context.Background()
+	key := "100000000/Covid-19 Immunization Record/v1.pdf"
+	body := []byte("%PDF-1.4 test record")
+	err := store.put(ctx, key, bytes.NewReader(body), int64(len(body)), "application/pdf")
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	ok, err := store.exists(ctx, key)
+	if err != nil || !ok {
+		t.Fatalf("exists after put = %v, %v", ok, err)
+	}
+	reader, err := store.get(ctx, key)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	got, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("get returned %q, %v", got, err)
+	}
+	err = store.delete(ctx, key)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	ok, err = store.exists(ctx, key)
+	if err != nil || ok {
+		t.Fatalf("exists after delete = %v, %v", ok, err)
+	}
+	_, err = store.get(ctx, key)
+	if !errors.Is(err, errNoBlob) {
+		t.Fatalf("get after delete = %v, want errNoBlob", err)
+	}
+	err = store.delete(ctx, key)
+	if err != nil {
+		t.Fatalf("delete of a missing blob: %v", err)
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	exerciseBlobStore(t, localBlobStore{root: t.TempDir()})
+}
+
+func TestLocalBlobStoreRejectsEscapingKeys(t *testing.T) {
+	store := localBlobStore{root: t.TempDir()}
+	err := store.put(context.Background(), "../outside.pdf", bytes.NewReader(nil), 0, "application/pdf")
+	if err == nil {
+		t.Fatal("put outside the root succeeded")
+	}
+}
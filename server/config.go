@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	_ "time/tzdata"
+)
+
+type termConfig struct {
+	Name     string   `json:"name"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Holidays []string `json:"holidays"`
+}
+
+type smtpConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	From     string `json:"from"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type notificationConfig struct {
+	HoldMinutes       int        `json:"holdMinutes"`
+	RateLimit         int        `json:"rateLimit"`
+	RateWindowMinutes int        `json:"rateWindowMinutes"`
+	SMTP              smtpConfig `json:"smtp"`
+}
+
+type recordsConfig struct {
+	Root          string `json:"root"`
+	MaxUploadMB   int64  `json:"maxUploadMB"`
+	QuotaMB       int64  `json:"quotaMB"`
+	RetentionDays int    `json:"retentionDays"`
+}
+
+type documentConfig struct {
+	Name              string `json:"name"`
+	Description       string `json:"description"`
+	Deadline          string `json:"deadline"`
+	ExpiresDays       int    `json:"expiresDays"`
+	HoldsRegistration bool   `json:"holdsRegistration"`
+	HoldType          string `json:"holdType"`
+}
+
+type periodConfig struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	SubmitBy  string `json:"submitBy"`
+	ApproveBy string `json:"approveBy"`
+}
+
+type payPeriodConfig struct {
+	Schedule       string         `json:"schedule"`
+	Anchor         string         `json:"anchor"`
+	Periods        []periodConfig `json:"periods"`
+	SubmissionDays int            `json:"submissionDays"`
+	ApprovalDays   int            `json:"approvalDays"`
+	ReminderHours  int            `json:"reminderHours"`
+}
+
+type timesheetConfig struct {
+	MaxShiftHours       float64         `json:"maxShiftHours"`
+	MaxDayHours         float64         `json:"maxDayHours"`
+	MaxWeekHours        float64         `json:"maxWeekHours"`
+	PayPeriods          payPeriodConfig `json:"payPeriods"`
+	AwardWarningPercent float64         `json:"awardWarningPercent"`
+	OvertimeWeeklyHours float64         `json:"overtimeWeeklyHours"`
+	OvertimeMultiplier  float64         `json:"overtimeMultiplier"`
+}
+
+type matchingConfig struct {
+	Weights          map[string]float64 `json:"weights"`
+	MinScore         float64            `json:"minScore"`
+	ConflictingNeeds [][]string         `json:"conflictingNeeds"`
+}
+
+type tuitionBand struct {
+	MinCredits      float64 `json:"minCredits"`
+	Flat            float64 `json:"flat"`
+	PerCredit       float64 `json:"perCredit"`
+	IncludedCredits float64 `json:"includedCredits"`
+}
+
+type billingConfig struct {
+	DefaultResidency string                   `json:"defaultResidency"`
+	Tuition          map[string][]tuitionBand `json:"tuition"`
+	CourseFees       map[string]float64       `json:"courseFees"`
+}
+
+type paymentPlanConfig struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Fee         float64  `json:"fee"`
+	DueDates    []string `json:"dueDates"`
+}
+
+type paymentsConfig struct {
+	Provider      string              `json:"provider"`
+	WebhookSecret string              `json:"webhookSecret"`
+	DueDate       string              `json:"dueDate"`
+	PaymentDays   int                 `json:"paymentDays"`
+	GraceDays     int                 `json:"graceDays"`
+	Plans         []paymentPlanConfig `json:"plans"`
+}
+
+type housingConfig struct {
+	Matching matchingConfig `json:"matching"`
+}
+
+type s3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+type storageConfig struct {
+	Backend string   `json:"backend"`
+	S3      s3Config `json:"s3"`
+}
+
+type serverConfig struct {
+	TimeZone      string             `json:"timeZone"`
+	Term          termConfig         `json:"term"`
+	Notifications notificationConfig `json:"notifications"`
+	Records       recordsConfig      `json:"records"`
+	Storage       storageConfig      `json:"storage"`
+	Documents     []documentConfig   `json:"documents"`
+	Timesheets    timesheetConfig    `json:"timesheets"`
+	Housing       housingConfig      `json:"housing"`
+	Billing       billingConfig      `json:"billing"`
+	Payments      paymentsConfig     `json:"payments"`
+}
+
+type zonedTime struct {
+	Time     time.Time `json:"time"`
+	TimeZone string    `json:"timeZone"`
+}
+
+var (
+	appConfig      serverConfig
+	campusLocation = time.UTC
+)
+
+func loadConfig(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&appConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if appConfig.TimeZone == "" {
+		return fmt.Errorf("config is missing the campus timeZone")
+	}
+	campusLocation, err = time.LoadLocation(appConfig.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid campus timeZone %s: %v", appConfig.TimeZone, err)
+	}
+	for _, document := range appConfig.Documents {
+		if document.Deadline == "" {
+			continue
+		}
+		_, err = parseCampusDate(document.Deadline)
+		if err != nil {
+			return fmt.Errorf("invalid deadline for document %s: %v", document.Name, err)
+		}
+	}
+	err = validatePayPeriods(appConfig.Timesheets.PayPeriods)
+	if err != nil {
+		return err
+	}
+	err = validateBilling(appConfig.Billing)
+	if err != nil {
+		return err
+	}
+	return validatePayments(appConfig.Payments)
+}
+
+func inCampusZone(t time.Time) zonedTime {
+	return zonedTime{
+		Time:     t.In(campusLocation),
+		TimeZone: campusLocation.String(),
+	}
+}
+
+func parseCampusDate(value string) (time.Time, error) {
+	date, err := time.ParseInLocation("2006-01-02", value, campusLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %s: %v", value, err)
+	}
+	return date, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type documentStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Deadline    string     `json:"deadline,omitempty"`
+	Status      string     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	Version     int        `json:"version,omitempty"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Blocking    bool       `json:"blocking"`
+}
+
+var errInvalidReview = errors.New("invalid review")
+
+func handleGetRequiredDocuments(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	checklist, err := documentChecklist(request.Id, time.Now())
+	if err != nil {
+		http.Error(w, "Error with getting required documents", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(checklist)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleGetPendingReviews(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		StaffId string `json:"staffId"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.StaffId, "staff") {
+		return
+	}
+	pending, err := getPendingReviews()
+	if err != nil {
+		http.Error(w, "Error with getting pending reviews", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(pending)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleReviewRecord(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		StaffId  string `json:"staffId"`
+		Id       string `json:"id"`
+		Filename string `json:"filename"`
+		Version  int    `json:"version"`
+		Status   string `json:"status"`
+		Reason   string `json:"reason"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.StaffId, "staff") {
+		return
+	}
+	err = reviewRecord(request.Id, request.Filename, request.Version, request.Status, request.Reason, request.StaffId)
+	if errors.Is(err, errInvalidReview) {
+		http.Error(w, "Status must be approved, or rejected with a reason", http.StatusBadRequest)
+		return
+	}
+	if err == errNoRecord {
+		http.Error(w, "Record version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reviewing record", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func findDocumentType(name string) (documentConfig, bool) {
+	for _, document := range appConfig.Documents {
+		if document.Name == name {
+			return document, true
+		}
+	}
+	return documentConfig{}, false
+}
+
+func documentChecklist(id string, now time.Time) ([]documentStatus, error) {
+	records, err := getRecords(id, false)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]recordMeta)
+	for _, record := range records {
+		current[record.Name] = record
+	}
+	checklist := []documentStatus{}
+	for _, document := range appConfig.Documents {
+		status := documentStatus{
+			Name:        document.Name,
+			Description: document.Description,
+			Deadline:    document.Deadline,
+			Status:      "missing",
+		}
+		if record, ok := current[document.Name]; ok {
+			status.Status = record.Status
+			status.Reason = record.Reason
+			status.Version = record.Version
+			if record.Status == "approved" && document.ExpiresDays > 0 {
+				expires := record.Uploaded.AddDate(0, 0, document.ExpiresDays)
+				status.Expires = &expires
+				if !now.Before(expires) {
+					status.Status = "expired"
+				}
+			}
+		}
+		pastDeadline := false
+		if document.Deadline != "" {
+			deadline, err := parseCampusDate(document.Deadline)
+			if err != nil {
+				return nil, err
+			}
+			pastDeadline = !now.Before(deadline.AddDate(0, 0, 1))
+		}
+		switch status.Status {
+		case "expired":
+			status.Blocking = document.HoldsRegistration
+		case "missing", "rejected":
+			status.Blocking = document.HoldsRegistration && pastDeadline
+		}
+		checklist = append(checklist, status)
+	}
+	return checklist, nil
+}
+
+func reviewRecord(id string, name string, version int, status string, reason string, reviewer string) error {
+	if (status != "approved" && status != "rejected") || (status == "rejected" && reason == "") {
+		return errInvalidReview
+	}
+	if status == "approved" {
+		reason = ""
+	}
+	versions := dbClient.Database(dbName).Collection("recordVersions")
+	records := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"owner": id, "name": name, "version": version}
+	update := bson.M{
+		"$set": bson.M{
+			"status":   status,
+			"reason":   reason,
+			"reviewer": reviewer,
+			"reviewed": time.Now(),
+		},
+	}
+	result, err := versions.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to review version %d of record %s: %v", version, name, err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoRecord
+	}
+	_, err = records.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update record %s: %v", name, err)
+	}
+	message := fmt.Sprintf("Your %s (version %d) was approved.", name, version)
+	if status == "rejected" {
+		message = fmt.Sprintf("Your %s (version %d) was rejected: %s", name, version, reason)
+	}
+	go notifyUser(id, name+" "+status, message, "")
+	log.Printf("%s %s version %d of %s for %s", reviewer, status, version, name, id)
+	return nil
+}
+
+func getPendingReviews() ([]map[string]interface{}, error) {
+	collection := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var names []string
+	for _, document := range appConfig.Documents {
+		names = append(names, document.Name)
+	}
+	filter := bson.M{
+		"name":    bson.M{"$in": names},
+		"status":  "pending",
+		"deleted": bson.M{"$exists": false},
+	}
+	opts := options.Find().SetSort(bson.M{"uploaded": 1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending reviews: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var records []recordMeta
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	pending := []map[string]interface{}{}
+	for _, record := range records {
+		pending = append(pending, map[string]interface{}{
+			"id":       record.Owner,
+			"name":     record.Name,
+			"version":  record.Version,
+			"uploader": record.Uploader,
+			"uploaded": record.Uploaded,
+		})
+	}
+	return pending, nil
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errNoDraft = errors.New("draft not found")
+
+type draft struct {
+	Id      string    `bson:"id"`
+	Name    string    `bson:"name"`
+	Classes []string  `bson:"classes"`
+	Updated time.Time `bson:"updated"`
+}
+
+type draftReport struct {
+	Name      string              `json:"name"`
+	Credits   float64             `json:"credits"`
+	Days      string              `json:"days"`
+	Conflicts [][2]string         `json:"conflicts"`
+	Prereqs   []map[string]string `json:"prereqs"`
+	Full      []string            `json:"full"`
+	Missing   []string            `json:"missing"`
+}
+
+func (report draftReport) ok() bool {
+	return len(report.Conflicts) == 0 && len(report.Prereqs) == 0 && len(report.Full) == 0 && len(report.Missing) == 0
+}
+
+func handleSaveDraft(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id      string                   `json:"id"`
+		Name    string                   `json:"name"`
+		Classes []map[string]interface{} `json:"classes"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Name) == "" {
+		http.Error(w, "Draft name is required", http.StatusBadRequest)
+		return
+	}
+	classes, err := classKeys(request.Classes)
+	if err != nil {
+		http.Error(w, "Invalid class data", http.StatusBadRequest)
+		return
+	}
+	err = saveDraft(request.Id, strings.TrimSpace(request.Name), classes)
+	if err != nil {
+		http.Error(w, "Error saving draft to MongoDB", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetDrafts(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	drafts, err := getDrafts(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting drafts", http.StatusInternalServerError)
+		return
+	}
+	var responses []map[string]interface{}
+	for _, d := range drafts {
+		sections, missing, err := resolveDraft(d)
+		if err != nil {
+			http.Error(w, "Error with getting drafts", http.StatusInternalServerError)
+			return
+		}
+		var classes []map[string]interface{}
+		for _, section := range sections {
+			response, ok := classResponse(section)
+			if !ok {
+				http.Error(w, "Invalid course data", http.StatusInternalServerError)
+				return
+			}
+			classes = append(classes, response)
+		}
+		responses = append(responses, map[string]interface{}{
+			"name":    d.Name,
+			"updated": d.Updated,
+			"classes": classes,
+			"missing": missing,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(responses)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleDeleteDraft(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	err = deleteDraft(request.Id, request.Name)
+	if errors.Is(err, errNoDraft) {
+		http.Error(w, "Draft doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deleting draft", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleCheckDraft(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	d, err := getDraft(request.Id, request.Name)
+	if errors.Is(err, errNoDraft) {
+		http.Error(w, "Draft doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error with getting draft", http.StatusInternalServerError)
+		return
+	}
+	report, err := checkDraft(d)
+	if err != nil {
+		http.Error(w, "Error checking draft", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleCompareDrafts(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id    string   `json:"id"`
+		Names []string `json:"names"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Names) < 2 {
+		http.Error(w, "At least two drafts are required", http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, name := range request.Names {
+		if seen[name] {
+			http.Error(w, "Draft "+name+" is listed more than once", http.StatusBadRequest)
+			return
+		}
+		seen[name] = true
+	}
+	var reports []draftReport
+	var drafts []draft
+	counts := make(map[string]int)
+	for _, name := range request.Names {
+		d, err := getDraft(request.Id, name)
+		if errors.Is(err, errNoDraft) {
+			http.Error(w, "Draft "+name+" doesn't exist", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error with getting draft", http.StatusInternalServerError)
+			return
+		}
+		report, err := checkDraft(d)
+		if err != nil {
+			http.Error(w, "Error checking draft", http.StatusInternalServerError)
+			return
+		}
+		reports = append(reports, report)
+		drafts = append(drafts, d)
+		inDraft := make(map[string]bool)
+		for _, clas := range d.Classes {
+			if !inDraft[clas] {
+				inDraft[clas] = true
+				counts[clas]++
+			}
+		}
+	}
+	var shared []string
+	for clas, count := range counts {
+		if count == len(request.Names) {
+			shared = append(shared, clas)
+		}
+	}
+	sort.Strings(shared)
+	unique := make(map[string][]string)
+	for _, d := range drafts {
+		unique[d.Name] = []string{}
+		for _, clas := range d.Classes {
+			if counts[clas] == 1 {
+				unique[d.Name] = append(unique[d.Name], clas)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"drafts": reports,
+		"shared": shared,
+		"unique": unique,
+	})
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handlePromoteDraft(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	d, err := getDraft(request.Id, request.Name)
+	if errors.Is(err, errNoDraft) {
+		http.Error(w, "Draft doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error with getting draft", http.StatusInternalServerError)
+		return
+	}
+	report, err := checkDraft(d)
+	if err != nil {
+		http.Error(w, "Error checking draft", http.StatusInternalServerError)
+		return
+	}
+	if !report.ok() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Draft " + d.Name + " cannot be enrolled as is",
+			"report": report,
+		})
+		return
+	}
+	err = promoteDraft(request.Id, d.Classes)
+	if err != nil {
+		sendConflict(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func resolveDraft(d draft) ([]bson.M, []string, error) {
+	var sections []bson.M
+	var missing []string
+	for _, clas := range d.Classes {
+		course, code, section, err := parseClassKey(clas)
+		if err != nil {
+			missing = append(missing, clas)
+			continue
+		}
+		result, err := searchClass(course, code, section)
+		if err != nil {
+			if errors.Is(err, errNoClass) {
+				missing = append(missing, clas)
+				continue
+			}
+			return nil, nil, err
+		}
+		sections = append(sections, result)
+	}
+	return sections, missing, nil
+}
+
+func checkDraft(d draft) (draftReport, error) {
+	report := draftReport{
+		Name:      d.Name,
+		Conflicts: [][2]string{},
+		Prereqs:   []map[string]string{},
+		Full:      []string{},
+	}
+	sections, missing, err := resolveDraft(d)
+	if err != nil {
+		return report, err
+	}
+	report.Missing = append([]string{}, missing...)
+	current, err := getCurrent(d.Id)
+	if err != nil {
+		return report, err
+	}
+	enrolled := make(map[string]bool)
+	for _, section := range current {
+		enrolled[sectionKey(section)] = true
+	}
+	days := make(map[rune]bool)
+	for i, section := range sections {
+		course, ok := section["course"].(bson.M)
+		if !ok {
+			return report, fmt.Errorf("invalid course data")
+		}
+		key := sectionKey(section)
+		if credits, ok := course["credits"].(float64); ok {
+			report.Credits += credits
+		}
+		for _, m := range sectionMeetings(section) {
+			for _, day := range m.Days {
+				days[day] = true
+			}
+		}
+		if size, ok := numberValue(section["size"]); ok && size <= 0 && !enrolled[key] {
+			held, err := hasSeatHold(d.Id, section["_id"])
+			if err != nil {
+				return report, err
+			}
+			if !held {
+				report.Full = append(report.Full, key)
+			}
+		}
+		prereq, _ := course["prereq"].(string)
+		message, err := checkPrereqs(prereq, d.Id)
+		if err != nil {
+			return report, err
+		}
+		if message != "" {
+			report.Prereqs = append(report.Prereqs, map[string]string{"class": key, "error": message})
+		}
+		for _, other := range sections[i+1:] {
+			if sectionsConflict(section, other) {
+				report.Conflicts = append(report.Conflicts, [2]string{key, sectionKey(other)})
+			}
+		}
+	}
+	for _, day := range "UMTWRFS" {
+		if days[day] {
+			report.Days += string(day)
+		}
+	}
+	return report, nil
+}
+
+func saveDraft(id string, name string, classes []string) error {
+	collection := dbClient.Database(dbName).Collection("drafts")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"id": id, "name": name}
+	update := bson.M{
+		"$set": bson.M{
+			"classes": classes,
+			"updated": time.Now(),
+		},
+	}
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save draft %s for user with id %s: %v", name, id, err)
+	}
+	return nil
+}
+
+func getDrafts(id string) ([]draft, error) {
+	collection := dbClient.Database(dbName).Collection("drafts")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"id": id,
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch drafts: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var results []draft
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func getDraft(id string, name string) (draft, error) {
+	collection := dbClient.Database(dbName).Collection("drafts")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"id":   id,
+		"name": name,
+	}
+	var result draft
+	err := collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return draft{}, errNoDraft
+		}
+		return draft{}, fmt.Errorf("failed to fetch draft: %v", err)
+	}
+	return result, nil
+}
+
+func deleteDraft(id string, name string) error {
+	collection := dbClient.Database(dbName).Collection("drafts")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"id":   id,
+		"name": name,
+	}
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to delete draft: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return errNoDraft
+	}
+	return nil
+}
+
+func promoteDraft(id string, classes []string) error {
+	users := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	current, err := getCurrent(id)
+	if err != nil {
+		return err
+	}
+	err = checkRegistrationBlocks(classes, current, id)
+	if err != nil {
+		return err
+	}
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	var changed []interface{}
+	var holds []*seatHold
+	var waitlists []primitive.ObjectID
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		changed, holds, waitlists = nil, nil, nil
+		filter := bson.M{"id": id}
+		var user struct {
+			Current []bson.M `bson:"current"`
+		}
+		err := users.FindOne(sc, filter).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errNoUser
+			}
+			return nil, fmt.Errorf("failed to fetch 'current': %v", err)
+		}
+		keep := make(map[string]bool)
+		for _, clas := range classes {
+			keep[clas] = true
+		}
+		enrolled := make(map[string]bson.M)
+		for _, section := range user.Current {
+			key := sectionKey(section)
+			if keep[key] {
+				enrolled[key] = section
+				continue
+			}
+			sectionID, ok := section["_id"].(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			hold, err := releaseSeat(sc, sectionID)
+			if err != nil {
+				return nil, err
+			}
+			if hold != nil {
+				holds = append(holds, hold)
+			}
+			changed = append(changed, sectionID)
+		}
+		current := []bson.M{}
+		for _, clas := range classes {
+			if section, ok := enrolled[clas]; ok {
+				current = append(current, section)
+				continue
+			}
+			course, code, section, err := parseClassKey(clas)
+			if err != nil {
+				return nil, err
+			}
+			classFilter := bson.M{
+				"course.class": strings.Split(course, "/"),
+				"course.code":  code,
+				"section":      section,
+			}
+			updated, waitlisted, err := claimSeat(sc, classFilter, id)
+			if errors.Is(err, errClassFull) {
+				return nil, fmt.Errorf("%s has no seats available", clas)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to claim seat in %s: %v", clas, err)
+			}
+			current = append(current, updated)
+			changed = append(changed, updated["_id"])
+			if sectionID, ok := updated["_id"].(primitive.ObjectID); ok && waitlisted {
+				waitlists = append(waitlists, sectionID)
+			}
+		}
+		_, err = users.UpdateOne(sc, filter, bson.M{"$set": bson.M{"current": current}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update enrollment: %v", err)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	publishSeatsByID(changed)
+	publishCart(id, classes)
+	refreshCharges(id)
+	for _, section := range waitlists {
+		publishWaitlist(section)
+	}
+	for _, hold := range holds {
+		notifySeatHold(hold)
+	}
+	return nil
+}
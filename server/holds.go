@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type holdBlocks struct {
+	Registration bool `bson:"registration" json:"registration"`
+	Transcripts  bool `bson:"transcripts" json:"transcripts"`
+	Housing      bool `bson:"housing" json:"housing"`
+}
+
+type studentHold struct {
+	ObjectId   primitive.ObjectID `bson:"_id,omitempty" json:"holdId"`
+	Id         string             `bson:"id" json:"id"`
+	Type       string             `bson:"type" json:"type"`
+	Source     string             `bson:"source" json:"source"`
+	Reason     string             `bson:"reason" json:"reason"`
+	Blocks     holdBlocks         `bson:"blocks" json:"blocks"`
+	PlacedBy   string             `bson:"placedBy" json:"placedBy"`
+	Created    time.Time          `bson:"created" json:"created"`
+	Resolved   *time.Time         `bson:"resolved,omitempty" json:"resolved,omitempty"`
+	ReleasedBy string             `bson:"releasedBy,omitempty" json:"releasedBy,omitempty"`
+	ActiveKey  string             `bson:"activeKey,omitempty" json:"-"`
+}
+
+var (
+	holdTypes = map[string]holdBlocks{
+		"financial":    {Registration: true, Transcripts: true},
+		"advising":     {Registration: true},
+		"immunization": {Registration: true, Housing: true},
+		"conduct":      {Registration: true, Transcripts: true, Housing: true},
+		"document":     {Registration: true},
+	}
+	errNoHold          = errors.New("hold not found")
+	errInvalidHold     = errors.New("invalid hold")
+	errAutomaticHold   = errors.New("hold is managed automatically")
+	documentHoldPrefix = "document:"
+	billingHoldPrefix  = "billing:"
+)
+
+func handleGetHolds(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id  string `json:"id"`
+		All bool   `json:"all"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	err = syncDocumentHolds(request.Id)
+	if err == nil {
+		err = syncFinancialHold(request.Id)
+	}
+	if err != nil {
+		http.Error(w, "Error with getting holds", http.StatusInternalServerError)
+		return
+	}
+	holds, err := getHolds(request.Id, request.All)
+	if err != nil {
+		http.Error(w, "Error with getting holds", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(holds)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handlePlaceHold(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId string      `json:"adminId"`
+		Id      string      `json:"id"`
+		Type    string      `json:"type"`
+		Source  string      `json:"source"`
+		Reason  string      `json:"reason"`
+		Blocks  *holdBlocks `json:"blocks"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	hold, err := placeHold(request.Id, request.Type, request.Source, request.Reason, request.Blocks, request.AdminId)
+	if errors.Is(err, errInvalidHold) {
+		http.Error(w, "Holds need a known type, a source and a reason", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errAutomaticHold) {
+		http.Error(w, "Document and billing holds are placed automatically", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errNoUser) {
+		http.Error(w, "User doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error placing hold", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(hold)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId string `json:"adminId"`
+		HoldId  string `json:"holdId"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	holdID, err := primitive.ObjectIDFromHex(request.HoldId)
+	if err != nil {
+		http.Error(w, "Invalid hold id", http.StatusBadRequest)
+		return
+	}
+	err = releaseHold(holdID, request.AdminId)
+	if errors.Is(err, errNoHold) {
+		http.Error(w, "Hold doesn't exist or is already released", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errAutomaticHold) {
+		sendConflict(w, "This hold is released automatically once the document is approved or the balance is paid")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error releasing hold", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func getHolds(id string, all bool) ([]studentHold, error) {
+	collection := dbClient.Database(dbName).Collection("holds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"id": id}
+	if !all {
+		filter["resolved"] = bson.M{"$exists": false}
+	}
+	opts := options.Find().SetSort(bson.M{"created": -1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch holds: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []studentHold{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func placeHold(id string, holdType string, source string, reason string, blocks *holdBlocks, placedBy string) (studentHold, error) {
+	defaults, ok := holdTypes[holdType]
+	if !ok || source == "" || reason == "" {
+		return studentHold{}, errInvalidHold
+	}
+	if automaticHold(source) {
+		return studentHold{}, errAutomaticHold
+	}
+	if blocks == nil {
+		blocks = &defaults
+	}
+	users := dbClient.Database(dbName).Collection("users")
+	collection := dbClient.Database(dbName).Collection("holds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := users.CountDocuments(ctx, bson.M{"id": id})
+	if err != nil {
+		return studentHold{}, fmt.Errorf("failed to fetch user with id %s: %v", id, err)
+	}
+	if count == 0 {
+		return studentHold{}, errNoUser
+	}
+	hold := studentHold{
+		Id:       id,
+		Type:     holdType,
+		Source:   source,
+		Reason:   reason,
+		Blocks:   *blocks,
+		PlacedBy: placedBy,
+		Created:  time.Now(),
+	}
+	result, err := collection.InsertOne(ctx, hold)
+	if err != nil {
+		return studentHold{}, fmt.Errorf("failed to insert hold: %v", err)
+	}
+	hold.ObjectId = result.InsertedID.(primitive.ObjectID)
+	go notifyUser(id, "New "+holdType+" hold", fmt.Sprintf("%s placed a hold on your account: %s", source, reason), "")
+	return hold, nil
+}
+
+func releaseHold(holdID primitive.ObjectID, releasedBy string) error {
+	collection := dbClient.Database(dbName).Collection("holds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var hold studentHold
+	err := collection.FindOne(ctx, bson.M{"_id": holdID, "resolved": bson.M{"$exists": false}}).Decode(&hold)
+	if err == mongo.ErrNoDocuments {
+		return errNoHold
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch hold: %v", err)
+	}
+	if automaticHold(hold.Source) {
+		return errAutomaticHold
+	}
+	return resolveHold(ctx, holdID, releasedBy)
+}
+
+func automaticHold(source string) bool {
+	return strings.HasPrefix(source, documentHoldPrefix) || strings.HasPrefix(source, billingHoldPrefix)
+}
+
+func ensureHoldIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("holds").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "activeKey", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create holds index: %v", err)
+	}
+}
+
+// upsertAutomaticHold places hold unless the student already has an
+// unresolved hold from the same source. activeKey is unique while the hold
+// is unresolved, so concurrent syncs cannot both insert.
+func upsertAutomaticHold(ctx context.Context, hold studentHold) (bool, error) {
+	collection := dbClient.Database(dbName).Collection("holds")
+	hold.ActiveKey = hold.Id + "/" + hold.Source
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"id": hold.Id, "source": hold.Source, "resolved": bson.M{"$exists": false}},
+		bson.M{"$setOnInsert": hold},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to insert hold: %v", err)
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+func resolveHold(ctx context.Context, holdID primitive.ObjectID, releasedBy string) error {
+	collection := dbClient.Database(dbName).Collection("holds")
+	filter := bson.M{"_id": holdID, "resolved": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set":   bson.M{"resolved": time.Now(), "releasedBy": releasedBy},
+		"$unset": bson.M{"activeKey": ""},
+	}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to release hold: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoHold
+	}
+	return nil
+}
+
+func syncDocumentHolds(id string) error {
+	checklist, err := documentChecklist(id, time.Now())
+	if err != nil {
+		return err
+	}
+	active, err := getHolds(id, false)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]studentHold)
+	for _, hold := range active {
+		if strings.HasPrefix(hold.Source, documentHoldPrefix) {
+			existing[hold.Source] = hold
+		}
+	}
+	collection := dbClient.Database(dbName).Collection("holds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, status := range checklist {
+		source := documentHoldPrefix + status.Name
+		hold, held := existing[source]
+		delete(existing, source)
+		if !status.Blocking {
+			if held {
+				err = resolveHold(ctx, hold.ObjectId, "system")
+				if err != nil && err != errNoHold {
+					return err
+				}
+			}
+			continue
+		}
+		reason := documentHoldReason(status)
+		if held && hold.Reason == reason {
+			continue
+		}
+		if held {
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": hold.ObjectId}, bson.M{"$set": bson.M{"reason": reason}})
+			if err != nil {
+				return fmt.Errorf("failed to update hold: %v", err)
+			}
+			continue
+		}
+		document, _ := findDocumentType(status.Name)
+		holdType := document.HoldType
+		if _, ok := holdTypes[holdType]; !ok {
+			holdType = "document"
+		}
+		_, err = upsertAutomaticHold(ctx, studentHold{
+			Id:       id,
+			Type:     holdType,
+			Source:   source,
+			Reason:   reason,
+			Blocks:   holdTypes[holdType],
+			PlacedBy: "system",
+			Created:  time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, hold := range existing {
+		err = resolveHold(ctx, hold.ObjectId, "system")
+		if err != nil && err != errNoHold {
+			return err
+		}
+	}
+	return nil
+}
+
+func documentHoldReason(status documentStatus) string {
+	switch status.Status {
+	case "expired":
+		return status.Name + " has expired"
+	case "rejected":
+		return status.Name + " was rejected: " + status.Reason
+	default:
+		return status.Name + " was due " + status.Deadline
+	}
+}
+
+func blockingHolds(id string, action string) ([]studentHold, error) {
+	err := syncDocumentHolds(id)
+	if err != nil {
+		return nil, err
+	}
+	err = syncFinancialHold(id)
+	if err != nil {
+		return nil, err
+	}
+	active, err := getHolds(id, false)
+	if err != nil {
+		return nil, err
+	}
+	var blocking []studentHold
+	for _, hold := range active {
+		if (action == "registration" && hold.Blocks.Registration) ||
+			(action == "transcripts" && hold.Blocks.Transcripts) ||
+			(action == "housing" && hold.Blocks.Housing) {
+			blocking = append(blocking, hold)
+		}
+	}
+	return blocking, nil
+}
+
+func holdsError(action string, holds []studentHold) error {
+	var reasons []string
+	for _, hold := range holds {
+		if strings.HasPrefix(hold.Source, documentHoldPrefix) {
+			reasons = append(reasons, fmt.Sprintf("%s hold: %s", hold.Type, hold.Reason))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("%s hold from %s: %s", hold.Type, hold.Source, hold.Reason))
+		}
+	}
+	return fmt.Errorf("%s is blocked by %s", action, strings.Join(reasons, "; "))
+}
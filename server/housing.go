@@ -0,0 +1,751 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type bed struct {
+	BedId     primitive.ObjectID `bson:"_id,omitempty" json:"bedId"`
+	Building  string             `bson:"building" json:"building"`
+	Room      string             `bson:"room" json:"room"`
+	Bed       string             `bson:"bed" json:"bed"`
+	Floor     int                `bson:"floor" json:"floor"`
+	RoomType  string             `bson:"roomType" json:"roomType"`
+	Rate      float64            `bson:"rate" json:"rate"`
+	Occupant  string             `bson:"occupant,omitempty" json:"-"`
+	Claimed   *time.Time         `bson:"claimed,omitempty" json:"-"`
+	ClaimedBy string             `bson:"claimedBy,omitempty" json:"-"`
+}
+
+type housingRoom struct {
+	Building  string    `json:"building"`
+	Room      string    `json:"room"`
+	Floor     int       `json:"floor"`
+	RoomType  string    `json:"roomType"`
+	Rate      float64   `json:"rate"`
+	Capacity  int       `json:"capacity"`
+	Available int       `json:"available"`
+	Beds      []bedView `json:"beds"`
+}
+
+type bedView struct {
+	BedId    primitive.ObjectID `json:"bedId"`
+	Bed      string             `json:"bed"`
+	Occupied bool               `json:"occupied"`
+}
+
+type housingPreferences struct {
+	Buildings []string `bson:"buildings" json:"buildings"`
+	RoomTypes []string `bson:"roomTypes" json:"roomTypes"`
+	Notes     string   `bson:"notes,omitempty" json:"notes,omitempty"`
+}
+
+type housingApplication struct {
+	Id          string             `bson:"id" json:"id"`
+	Preferences housingPreferences `bson:"preferences" json:"preferences"`
+	Submitted   time.Time          `bson:"submitted" json:"submitted"`
+	Updated     time.Time          `bson:"updated" json:"updated"`
+}
+
+type housingGroup struct {
+	GroupId primitive.ObjectID `bson:"_id,omitempty" json:"groupId"`
+	Leader  string             `bson:"leader" json:"leader"`
+	Members []string           `bson:"members" json:"members"`
+	Invited []string           `bson:"invited" json:"invited"`
+	Created time.Time          `bson:"created" json:"created"`
+}
+
+var (
+	errHousingClosed  = errors.New("room selection has not opened yet")
+	errNoApplication  = errors.New("no housing application")
+	errBedTaken       = errors.New("bed is no longer available")
+	errHasBed         = errors.New("already assigned a bed")
+	errNoBed          = errors.New("bed not found")
+	errNoGroup        = errors.New("housing group not found")
+	errInGroup        = errors.New("already in a housing group")
+	errNotInGroup     = errors.New("not a member of the housing group")
+	errNotInvited     = errors.New("not invited to the housing group")
+	errHousingBlocked = errors.New("housing selection is blocked")
+	errInvalidHousing = errors.New("invalid housing request")
+)
+
+func readHousingRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return false
+	}
+	err = json.Unmarshal(body, request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func sendHousingResult(w http.ResponseWriter, err error, result interface{}) {
+	switch {
+	case errors.Is(err, errInvalidHousing):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errNoBed), errors.Is(err, errNoGroup):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errHousingClosed), errors.Is(err, errNoApplication), errors.Is(err, errBedTaken),
+		errors.Is(err, errHasBed), errors.Is(err, errInGroup), errors.Is(err, errNotInGroup),
+		errors.Is(err, errNotInvited), errors.Is(err, errHousingBlocked):
+		sendConflict(w, err.Error())
+	case err != nil:
+		http.Error(w, "Error updating housing", http.StatusInternalServerError)
+	case result == nil:
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			http.Error(w, "Error sending response", http.StatusInternalServerError)
+		}
+	}
+}
+
+func handleGetHousing(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id string `json:"id"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	opens, err := getHousingDate(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting housing", http.StatusInternalServerError)
+		return
+	}
+	application, err := getHousingApplication(request.Id)
+	if err != nil && err != errNoApplication {
+		http.Error(w, "Error with getting housing", http.StatusInternalServerError)
+		return
+	}
+	group, err := getHousingGroup(request.Id)
+	if err != nil && err != errNoGroup {
+		http.Error(w, "Error with getting housing", http.StatusInternalServerError)
+		return
+	}
+	invites, err := getHousingInvites(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting housing", http.StatusInternalServerError)
+		return
+	}
+	assignment, err := getAssignedBed(request.Id)
+	if err != nil && err != errNoBed {
+		http.Error(w, "Error with getting housing", http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"opens":   inCampusZone(opens),
+		"open":    !time.Now().Before(opens),
+		"invites": invites,
+	}
+	if application.Id != "" {
+		response["application"] = application
+	}
+	if group.Leader != "" {
+		response["group"] = group
+	}
+	if assignment.Building != "" {
+		response["assignment"] = assignment
+	}
+	sendHousingResult(w, nil, response)
+}
+
+func handleSaveHousingApplication(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id          string             `json:"id"`
+		Preferences housingPreferences `json:"preferences"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	application, err := saveHousingApplication(request.Id, request.Preferences)
+	sendHousingResult(w, err, application)
+}
+
+func handleGetRooms(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id        string `json:"id"`
+		Building  string `json:"building"`
+		Available bool   `json:"available"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	application, err := getHousingApplication(request.Id)
+	if err != nil && err != errNoApplication {
+		http.Error(w, "Error with getting rooms", http.StatusInternalServerError)
+		return
+	}
+	rooms, err := getRooms(request.Building, request.Available, application.Preferences)
+	sendHousingResult(w, err, rooms)
+}
+
+func handleClaimBeds(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id     string `json:"id"`
+		Claims []struct {
+			Id    string `json:"id"`
+			BedId string `json:"bedId"`
+		} `json:"claims"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	claims := make(map[string]primitive.ObjectID)
+	beds := make(map[primitive.ObjectID]bool)
+	for _, claim := range request.Claims {
+		bedID, err := primitive.ObjectIDFromHex(claim.BedId)
+		if err != nil || claims[claim.Id] != primitive.NilObjectID || beds[bedID] {
+			sendHousingResult(w, fmt.Errorf("%w: each student needs one distinct bed", errInvalidHousing), nil)
+			return
+		}
+		claims[claim.Id] = bedID
+		beds[bedID] = true
+	}
+	if len(claims) == 0 {
+		sendHousingResult(w, fmt.Errorf("%w: choose at least one bed", errInvalidHousing), nil)
+		return
+	}
+	assigned, err := claimBeds(request.Id, claims, time.Now())
+	sendHousingResult(w, err, assigned)
+}
+
+func handleReleaseBed(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id string `json:"id"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	sendHousingResult(w, releaseBed(request.Id), nil)
+}
+
+func handleCreateHousingGroup(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id string `json:"id"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	group, err := createHousingGroup(request.Id)
+	sendHousingResult(w, err, group)
+}
+
+func handleInviteToHousingGroup(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id       string `json:"id"`
+		MemberId string `json:"memberId"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	err := inviteToHousingGroup(request.Id, request.MemberId)
+	if err == nil {
+		go notifyUser(request.MemberId, "Roommate group invitation", fmt.Sprintf("%s invited you to join their roommate group.", request.Id), "")
+	}
+	sendHousingResult(w, err, nil)
+}
+
+func handleJoinHousingGroup(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id      string `json:"id"`
+		GroupId string `json:"groupId"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	groupID, err := primitive.ObjectIDFromHex(request.GroupId)
+	if err != nil {
+		http.Error(w, "Invalid group id", http.StatusBadRequest)
+		return
+	}
+	group, err := joinHousingGroup(request.Id, groupID)
+	sendHousingResult(w, err, group)
+}
+
+func handleLeaveHousingGroup(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id string `json:"id"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	sendHousingResult(w, leaveHousingGroup(request.Id), nil)
+}
+
+func ensureHousingIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("beds").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "building", Value: 1}, {Key: "room", Value: 1}, {Key: "bed", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "occupant", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"occupant": bson.M{"$type": "string"}}),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create beds index: %v", err)
+	}
+	_, err = db.Collection("housingApplications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create housing applications index: %v", err)
+	}
+}
+
+func parseCSVAndInsertIntoBeds(csvFilePath string) error {
+	file, err := os.Open(csvFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV file: %v", err)
+	}
+	if len(rows) < 2 {
+		return fmt.Errorf("CSV file is empty or does not have a header row")
+	}
+	headers := rows[0]
+	collection := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	inserted := 0
+	for _, row := range rows[1:] {
+		if len(row) != len(headers) {
+			return fmt.Errorf("row length does not match header length: %v", row)
+		}
+		room := bed{}
+		beds := 0
+		for i, value := range row {
+			switch headers[i] {
+			case "building":
+				room.Building = value
+			case "room":
+				room.Room = value
+			case "roomType":
+				room.RoomType = value
+			case "floor":
+				room.Floor, err = strconv.Atoi(value)
+			case "beds":
+				beds, err = strconv.Atoi(value)
+			case "rate":
+				room.Rate, err = strconv.ParseFloat(value, 64)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to convert '%s' to a number: %v", headers[i], err)
+			}
+		}
+		for i := 0; i < beds; i++ {
+			room.Bed = string(rune('A' + i))
+			filter := bson.M{"building": room.Building, "room": room.Room, "bed": room.Bed}
+			result, err := collection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": room}, options.Update().SetUpsert(true))
+			if err != nil {
+				return fmt.Errorf("failed to insert bed: %v", err)
+			}
+			inserted += int(result.UpsertedCount)
+		}
+	}
+	fmt.Printf("Successfully inserted %d rows into the 'beds' collection.\n", inserted)
+	return nil
+}
+
+func validPreferences(preferences housingPreferences) error {
+	collection := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	buildings, err := collection.Distinct(ctx, "building", bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to fetch buildings: %v", err)
+	}
+	roomTypes, err := collection.Distinct(ctx, "roomType", bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to fetch room types: %v", err)
+	}
+	known := make(map[interface{}]bool)
+	for _, value := range append(buildings, roomTypes...) {
+		known[value] = true
+	}
+	for _, value := range append(append([]string{}, preferences.Buildings...), preferences.RoomTypes...) {
+		if !known[value] {
+			return fmt.Errorf("%w: unknown building or room type %s", errInvalidHousing, value)
+		}
+	}
+	return nil
+}
+
+func saveHousingApplication(id string, preferences housingPreferences) (housingApplication, error) {
+	if preferences.Buildings == nil {
+		preferences.Buildings = []string{}
+	}
+	if preferences.RoomTypes == nil {
+		preferences.RoomTypes = []string{}
+	}
+	err := validPreferences(preferences)
+	if err != nil {
+		return housingApplication{}, err
+	}
+	collection := dbClient.Database(dbName).Collection("housingApplications")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	update := bson.M{
+		"$set":         bson.M{"preferences": preferences, "updated": now},
+		"$setOnInsert": bson.M{"submitted": now},
+	}
+	var application housingApplication
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"id": id}, update, opts).Decode(&application)
+	if err != nil {
+		return housingApplication{}, fmt.Errorf("failed to save housing application: %v", err)
+	}
+	return application, nil
+}
+
+func getHousingApplication(id string) (housingApplication, error) {
+	collection := dbClient.Database(dbName).Collection("housingApplications")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var application housingApplication
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&application)
+	if err == mongo.ErrNoDocuments {
+		return housingApplication{}, errNoApplication
+	}
+	if err != nil {
+		return housingApplication{}, fmt.Errorf("failed to fetch housing application: %v", err)
+	}
+	return application, nil
+}
+
+func preferenceRank(values []string, value string) int {
+	for i, preferred := range values {
+		if preferred == value {
+			return i
+		}
+	}
+	return len(values)
+}
+
+func getRooms(building string, available bool, preferences housingPreferences) ([]housingRoom, error) {
+	collection := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{}
+	if building != "" {
+		filter["building"] = building
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "building", Value: 1}, {Key: "room", Value: 1}, {Key: "bed", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch beds: %v", err)
+	}
+	var beds []bed
+	if err = cursor.All(ctx, &beds); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	rooms := []housingRoom{}
+	index := make(map[string]int)
+	for _, b := range beds {
+		key := b.Building + "/" + b.Room
+		i, ok := index[key]
+		if !ok {
+			i = len(rooms)
+			index[key] = i
+			rooms = append(rooms, housingRoom{Building: b.Building, Room: b.Room, Floor: b.Floor, RoomType: b.RoomType, Rate: b.Rate})
+		}
+		rooms[i].Capacity++
+		if b.Occupant == "" {
+			rooms[i].Available++
+		}
+		rooms[i].Beds = append(rooms[i].Beds, bedView{BedId: b.BedId, Bed: b.Bed, Occupied: b.Occupant != ""})
+	}
+	results := []housingRoom{}
+	for _, room := range rooms {
+		if !available || room.Available > 0 {
+			results = append(results, room)
+		}
+	}
+	sort.SliceStable(results, func(a, b int) bool {
+		rankA := preferenceRank(preferences.Buildings, results[a].Building)
+		rankB := preferenceRank(preferences.Buildings, results[b].Building)
+		if rankA != rankB {
+			return rankA < rankB
+		}
+		return preferenceRank(preferences.RoomTypes, results[a].RoomType) < preferenceRank(preferences.RoomTypes, results[b].RoomType)
+	})
+	return results, nil
+}
+
+func getAssignedBed(id string) (bed, error) {
+	collection := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var assigned bed
+	err := collection.FindOne(ctx, bson.M{"occupant": id}).Decode(&assigned)
+	if err == mongo.ErrNoDocuments {
+		return bed{}, errNoBed
+	}
+	if err != nil {
+		return bed{}, fmt.Errorf("failed to fetch bed: %v", err)
+	}
+	return assigned, nil
+}
+
+func checkHousingEligible(id string) error {
+	_, err := getHousingApplication(id)
+	if err == errNoApplication {
+		return fmt.Errorf("%w for %s", errNoApplication, id)
+	}
+	if err != nil {
+		return err
+	}
+	holds, err := blockingHolds(id, "housing")
+	if err != nil {
+		return err
+	}
+	if len(holds) > 0 {
+		return fmt.Errorf("%w: %v", errHousingBlocked, holdsError("student "+id, holds))
+	}
+	return nil
+}
+
+func claimBeds(id string, claims map[string]primitive.ObjectID, now time.Time) ([]bed, error) {
+	opens, err := getHousingDate(id)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(opens) {
+		return nil, fmt.Errorf("%w: it opens %s", errHousingClosed, opens.In(campusLocation).Format("Jan 2 3:04 PM"))
+	}
+	if _, ok := claims[id]; !ok {
+		return nil, fmt.Errorf("%w: include a bed for yourself", errInvalidHousing)
+	}
+	if len(claims) > 1 {
+		group, err := getHousingGroup(id)
+		if err == errNoGroup {
+			return nil, fmt.Errorf("%w: only roommate group members can be pulled in", errNotInGroup)
+		}
+		if err != nil {
+			return nil, err
+		}
+		members := make(map[string]bool)
+		for _, member := range group.Members {
+			members[member] = true
+		}
+		for member := range claims {
+			if !members[member] {
+				return nil, fmt.Errorf("%w: %s", errNotInGroup, member)
+			}
+		}
+	}
+	for member := range claims {
+		if err := checkHousingEligible(member); err != nil {
+			return nil, err
+		}
+	}
+	collection := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	var assigned []bed
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		assigned = nil
+		for member, bedID := range claims {
+			update := bson.M{"$set": bson.M{"occupant": member, "claimed": now, "claimedBy": id}}
+			var claimed bed
+			err := collection.FindOneAndUpdate(sc, bson.M{"_id": bedID, "occupant": bson.M{"$exists": false}}, update,
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&claimed)
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, fmt.Errorf("%w: %s", errHasBed, member)
+			}
+			if err == mongo.ErrNoDocuments {
+				return nil, errBedTaken
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to claim bed: %v", err)
+			}
+			assigned = append(assigned, claimed)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range assigned {
+		log.Printf("%s claimed %s %s%s for %s", id, b.Building, b.Room, b.Bed, b.Occupant)
+		refreshCharges(b.Occupant)
+		if b.Occupant != id {
+			go notifyUser(b.Occupant, "Housing assigned", fmt.Sprintf("%s pulled you into %s room %s, bed %s.", id, b.Building, b.Room, b.Bed), "")
+		}
+	}
+	return assigned, nil
+}
+
+func releaseBed(id string) error {
+	collection := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.UpdateOne(ctx, bson.M{"occupant": id},
+		bson.M{"$unset": bson.M{"occupant": "", "claimed": "", "claimedBy": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to release bed: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return errNoBed
+	}
+	refreshCharges(id)
+	return nil
+}
+
+func getHousingGroup(id string) (housingGroup, error) {
+	collection := dbClient.Database(dbName).Collection("housingGroups")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var group housingGroup
+	err := collection.FindOne(ctx, bson.M{"members": id}).Decode(&group)
+	if err == mongo.ErrNoDocuments {
+		return housingGroup{}, errNoGroup
+	}
+	if err != nil {
+		return housingGroup{}, fmt.Errorf("failed to fetch housing group: %v", err)
+	}
+	return group, nil
+}
+
+func getHousingInvites(id string) ([]housingGroup, error) {
+	collection := dbClient.Database(dbName).Collection("housingGroups")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{"invited": id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch housing invites: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []housingGroup{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func createHousingGroup(id string) (housingGroup, error) {
+	_, err := getHousingGroup(id)
+	if err == nil {
+		return housingGroup{}, errInGroup
+	}
+	if err != errNoGroup {
+		return housingGroup{}, err
+	}
+	collection := dbClient.Database(dbName).Collection("housingGroups")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	group := housingGroup{Leader: id, Members: []string{id}, Invited: []string{}, Created: time.Now()}
+	result, err := collection.InsertOne(ctx, group)
+	if err != nil {
+		return housingGroup{}, fmt.Errorf("failed to create housing group: %v", err)
+	}
+	group.GroupId = result.InsertedID.(primitive.ObjectID)
+	return group, nil
+}
+
+func inviteToHousingGroup(id string, member string) error {
+	if member == "" || member == id {
+		return fmt.Errorf("%w: invite another student", errInvalidHousing)
+	}
+	group, err := getHousingGroup(id)
+	if err != nil {
+		return err
+	}
+	collection := dbClient.Database(dbName).Collection("housingGroups")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": group.GroupId}, bson.M{"$addToSet": bson.M{"invited": member}})
+	if err != nil {
+		return fmt.Errorf("failed to invite to housing group: %v", err)
+	}
+	return nil
+}
+
+func joinHousingGroup(id string, groupID primitive.ObjectID) (housingGroup, error) {
+	collection := dbClient.Database(dbName).Collection("housingGroups")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := collection.CountDocuments(ctx, bson.M{"members": id})
+	if err != nil {
+		return housingGroup{}, fmt.Errorf("failed to fetch housing group: %v", err)
+	}
+	if count > 0 {
+		return housingGroup{}, errInGroup
+	}
+	update := bson.M{"$pull": bson.M{"invited": id}, "$addToSet": bson.M{"members": id}}
+	var group housingGroup
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": groupID, "invited": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&group)
+	if err == mongo.ErrNoDocuments {
+		return housingGroup{}, errNotInvited
+	}
+	if err != nil {
+		return housingGroup{}, fmt.Errorf("failed to join housing group: %v", err)
+	}
+	return group, nil
+}
+
+func leaveHousingGroup(id string) error {
+	group, err := getHousingGroup(id)
+	if err != nil {
+		return err
+	}
+	collection := dbClient.Database(dbName).Collection("housingGroups")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if len(group.Members) == 1 {
+		_, err = collection.DeleteOne(ctx, bson.M{"_id": group.GroupId})
+		if err != nil {
+			return fmt.Errorf("failed to delete housing group: %v", err)
+		}
+		return nil
+	}
+	update := bson.M{"$pull": bson.M{"members": id}}
+	if group.Leader == id {
+		for _, member := range group.Members {
+			if member != id {
+				update["$set"] = bson.M{"leader": member}
+				break
+			}
+		}
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": group.GroupId}, update)
+	if err != nil {
+		return fmt.Errorf("failed to leave housing group: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	icalLocalLayout = "20060102T150405"
+	icalUTCLayout   = "20060102T150405Z"
+)
+
+var icalDays = map[rune]string{
+	'U': "SU",
+	'M': "MO",
+	'T': "TU",
+	'W': "WE",
+	'R': "TH",
+	'F': "FR",
+	'S': "SA",
+}
+
+var icalWeekdays = map[rune]time.Weekday{
+	'U': time.Sunday,
+	'M': time.Monday,
+	'T': time.Tuesday,
+	'W': time.Wednesday,
+	'R': time.Thursday,
+	'F': time.Friday,
+	'S': time.Saturday,
+}
+
+func handleDownloadCalendar(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	sendCalendar(w, request.Id, true)
+}
+
+func handleGetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id    string `json:"id"`
+		Reset bool   `json:"reset"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	token, err := getCalendarToken(request.Id, request.Reset)
+	if err != nil {
+		http.Error(w, "Error with getting calendar feed", http.StatusInternalServerError)
+		return
+	}
+	url := "http://" + r.Host + "/calendar/" + token + ".ics"
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"url":    url,
+		"webcal": "webcal://" + strings.TrimPrefix(url, "http://"),
+	})
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleCalendarSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/calendar/"), ".ics")
+	id, err := findUserByCalendarToken(token)
+	if err != nil {
+		http.Error(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+	sendCalendar(w, id, false)
+}
+
+func sendCalendar(w http.ResponseWriter, id string, attachment bool) {
+	current, err := getCurrent(id)
+	if err != nil {
+		http.Error(w, "Error with getting cart", http.StatusInternalServerError)
+		return
+	}
+	calendar, err := buildCalendar(current, time.Now())
+	if err != nil {
+		http.Error(w, "Error building calendar", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if attachment {
+		w.Header().Set("Content-Disposition", "attachment; filename=\"schedule.ics\"")
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, calendar)
+}
+
+func buildCalendar(sections []bson.M, stamp time.Time) (string, error) {
+	termStart, err := parseCampusDate(appConfig.Term.Start)
+	if err != nil {
+		return "", err
+	}
+	termEnd, err := parseCampusDate(appConfig.Term.End)
+	if err != nil {
+		return "", err
+	}
+	var holidays []time.Time
+	for _, value := range appConfig.Term.Holidays {
+		holiday, err := parseCampusDate(value)
+		if err != nil {
+			return "", err
+		}
+		holidays = append(holidays, holiday)
+	}
+	var lines []string
+	lines = append(lines,
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//polar//schedule//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:"+escapeICalText(appConfig.Term.Name+" Schedule"),
+		"X-WR-TIMEZONE:"+campusLocation.String(),
+	)
+	lines = append(lines, icalTimeZone(termStart, termEnd.AddDate(0, 0, 1))...)
+	for _, section := range sections {
+		course, ok := section["course"].(bson.M)
+		if !ok {
+			return "", fmt.Errorf("invalid course data")
+		}
+		summary := fmt.Sprintf("%s %v-%v", joinClass(course["class"]), course["code"], section["section"])
+		if title, ok := course["title"].(string); ok && title != "" {
+			summary += " " + title
+		}
+		instructor, _ := section["instructor"].(string)
+		for i, m := range sectionMeetings(section) {
+			event := meetingEvent(m, termStart, termEnd, holidays)
+			if event == nil {
+				continue
+			}
+			lines = append(lines,
+				"BEGIN:VEVENT",
+				fmt.Sprintf("UID:%v-%d@polar", section["_id"], i),
+				"DTSTAMP:"+stamp.UTC().Format(icalUTCLayout),
+				"SUMMARY:"+escapeICalText(summary),
+				"LOCATION:"+escapeICalText(m.Room),
+				"DESCRIPTION:"+escapeICalText("Instructor: "+instructor),
+			)
+			lines = append(lines, event...)
+			lines = append(lines, "END:VEVENT")
+		}
+	}
+	lines = append(lines, "END:VCALENDAR")
+	var builder strings.Builder
+	for _, line := range lines {
+		builder.WriteString(foldICalLine(line))
+		builder.WriteString("\r\n")
+	}
+	return builder.String(), nil
+}
+
+func meetingEvent(m meeting, termStart time.Time, termEnd time.Time, holidays []time.Time) []string {
+	var byDay []string
+	weekdays := make(map[time.Weekday]bool)
+	for _, day := range m.Days {
+		if code, ok := icalDays[day]; ok {
+			byDay = append(byDay, code)
+			weekdays[icalWeekdays[day]] = true
+		}
+	}
+	if len(byDay) == 0 {
+		return nil
+	}
+	if !m.StartDate.IsZero() && m.StartDate.After(termStart) {
+		termStart = m.StartDate
+	}
+	if !m.EndDate.IsZero() && m.EndDate.Before(termEnd) {
+		termEnd = m.EndDate
+	}
+	first := termStart
+	for !weekdays[first.Weekday()] {
+		first = first.AddDate(0, 0, 1)
+	}
+	if first.After(termEnd) {
+		return nil
+	}
+	at := func(date time.Time, minutes int) time.Time {
+		return time.Date(date.Year(), date.Month(), date.Day(), minutes/60, minutes%60, 0, 0, campusLocation)
+	}
+	tzid := ";TZID=" + campusLocation.String() + ":"
+	lines := []string{
+		"DTSTART" + tzid + at(first, m.Start).Format(icalLocalLayout),
+		"DTEND" + tzid + at(first, m.End).Format(icalLocalLayout),
+		"RRULE:FREQ=WEEKLY;BYDAY=" + strings.Join(byDay, ",") + ";UNTIL=" + at(termEnd, 24*60-1).UTC().Format(icalUTCLayout),
+	}
+	for _, holiday := range holidays {
+		if weekdays[holiday.Weekday()] && !holiday.Before(first) && !holiday.After(termEnd) {
+			lines = append(lines, "EXDATE"+tzid+at(holiday, m.Start).Format(icalLocalLayout))
+		}
+	}
+	return lines
+}
+
+func icalTimeZone(from time.Time, to time.Time) []string {
+	lines := []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:" + campusLocation.String(),
+	}
+	formatOffset := func(offset int) string {
+		sign := "+"
+		if offset < 0 {
+			sign = "-"
+			offset = -offset
+		}
+		return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+	}
+	t := from.In(campusLocation)
+	for t.Before(to) {
+		start, end := t.ZoneBounds()
+		if start.IsZero() || start.After(t) {
+			start = t
+		}
+		name, offset := t.Zone()
+		_, previous := start.Add(-time.Second).Zone()
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		lines = append(lines,
+			"BEGIN:"+kind,
+			"DTSTART:"+start.In(time.FixedZone("", previous)).Format(icalLocalLayout),
+			"TZOFFSETFROM:"+formatOffset(previous),
+			"TZOFFSETTO:"+formatOffset(offset),
+			"TZNAME:"+name,
+			"END:"+kind,
+		)
+		if end.IsZero() {
+			break
+		}
+		t = end
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+func joinClass(value interface{}) string {
+	switch v := value.(type) {
+	case bson.A:
+		var parts []string
+		for _, part := range v {
+			parts = append(parts, fmt.Sprint(part))
+		}
+		return strings.Join(parts, "/")
+	case []string:
+		return strings.Join(v, "/")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func escapeICalText(text string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n")
+	return replacer.Replace(text)
+}
+
+func foldICalLine(line string) string {
+	const limit = 75
+	if len(line) <= limit {
+		return line
+	}
+	var builder strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > limit {
+			builder.WriteString("\r\n ")
+			width = 1
+		}
+		builder.WriteRune(r)
+		width += size
+	}
+	return builder.String()
+}
+
+func getCalendarToken(id string, reset bool) (string, error) {
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"id": id,
+	}
+	var result struct {
+		CalendarToken string `bson:"calendarToken"`
+	}
+	err := collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", fmt.Errorf("user not found")
+		}
+		return "", fmt.Errorf("failed to fetch 'calendarToken': %v", err)
+	}
+	if result.CalendarToken != "" && !reset {
+		return result.CalendarToken, nil
+	}
+	buf := make([]byte, 24)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate calendar token: %v", err)
+	}
+	token := hex.EncodeToString(buf)
+	_, err = collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"calendarToken": token}})
+	if err != nil {
+		return "", fmt.Errorf("failed to save calendar token for user with id %s: %v", id, err)
+	}
+	return token, nil
+}
+
+func findUserByCalendarToken(token string) (string, error) {
+	if token == "" {
+		return "", errNoUser
+	}
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"calendarToken": token,
+	}
+	var result struct {
+		Id string `bson:"id"`
+	}
+	err := collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return "", errNoUser
+	}
+	return result.Id, nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type job struct {
+	JobId      primitive.ObjectID `bson:"_id,omitempty" json:"jobId"`
+	Id         string             `bson:"id" json:"id"`
+	Title      string             `bson:"title" json:"title"`
+	Department string             `bson:"department" json:"department"`
+	Supervisor string             `bson:"supervisor" json:"supervisor"`
+	HourlyRate float64            `bson:"hourlyRate" json:"hourlyRate"`
+	Start      string             `bson:"start" json:"start"`
+	End        string             `bson:"end,omitempty" json:"end,omitempty"`
+	Funding    string             `bson:"funding" json:"funding"`
+	AwardCap   float64            `bson:"awardCap,omitempty" json:"awardCap,omitempty"`
+	Warned     bool               `bson:"warned,omitempty" json:"-"`
+}
+
+type jobEarnings struct {
+	job
+	Hours     float64  `json:"hours"`
+	Earnings  float64  `json:"earnings"`
+	Remaining *float64 `json:"remaining,omitempty"`
+	Warning   bool     `json:"warning"`
+}
+
+var (
+	errNoJob       = errors.New("job not found")
+	errInvalidJob  = errors.New("invalid job")
+	fundingSources = map[string]bool{
+		"fws":           true,
+		"institutional": true,
+		"grant":         true,
+	}
+)
+
+func handleGetJobs(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	earnings, err := getJobEarnings(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting jobs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(earnings)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleSaveJob(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId string `json:"adminId"`
+		Job     job    `json:"job"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	saved, err := saveJob(request.Job)
+	if errors.Is(err, errInvalidJob) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == errNoJob {
+		http.Error(w, "Job doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving job", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s saved job %s for %s", request.AdminId, saved.JobId.Hex(), saved.Id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func validateJob(j job) error {
+	if j.Id == "" || j.Title == "" || j.Department == "" || j.Supervisor == "" {
+		return fmt.Errorf("%w: id, title, department and supervisor are required", errInvalidJob)
+	}
+	if j.HourlyRate <= 0 {
+		return fmt.Errorf("%w: hourly rate must be positive", errInvalidJob)
+	}
+	if !fundingSources[j.Funding] {
+		return fmt.Errorf("%w: funding must be fws, institutional or grant", errInvalidJob)
+	}
+	if j.Funding == "fws" && j.AwardCap <= 0 {
+		return fmt.Errorf("%w: Federal Work-Study jobs need an award cap", errInvalidJob)
+	}
+	start, err := parseCampusDate(j.Start)
+	if err != nil {
+		return fmt.Errorf("%w: invalid start date", errInvalidJob)
+	}
+	if j.End != "" {
+		end, err := parseCampusDate(j.End)
+		if err != nil || end.Before(start) {
+			return fmt.Errorf("%w: invalid end date", errInvalidJob)
+		}
+	}
+	return nil
+}
+
+func saveJob(j job) (job, error) {
+	if err := validateJob(j); err != nil {
+		return job{}, err
+	}
+	collection := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if j.JobId.IsZero() {
+		j.Warned = false
+		result, err := collection.InsertOne(ctx, j)
+		if err != nil {
+			return job{}, fmt.Errorf("failed to insert job: %v", err)
+		}
+		j.JobId = result.InsertedID.(primitive.ObjectID)
+		return j, nil
+	}
+	var existing job
+	err := collection.FindOne(ctx, bson.M{"_id": j.JobId, "id": j.Id}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return job{}, errNoJob
+	}
+	if err != nil {
+		return job{}, fmt.Errorf("failed to fetch job: %v", err)
+	}
+	set := bson.M{
+		"title":      j.Title,
+		"department": j.Department,
+		"supervisor": j.Supervisor,
+		"hourlyRate": j.HourlyRate,
+		"start":      j.Start,
+		"end":        j.End,
+		"funding":    j.Funding,
+		"awardCap":   j.AwardCap,
+	}
+	// A new or raised award gets its own near-cap warning.
+	if existing.AwardCap != j.AwardCap {
+		set["warned"] = false
+	}
+	var saved job
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": j.JobId, "id": j.Id}, bson.M{"$set": set}, opts).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		return job{}, errNoJob
+	}
+	if err != nil {
+		return job{}, fmt.Errorf("failed to update job: %v", err)
+	}
+	return saved, nil
+}
+
+func getJobs(id string) ([]job, error) {
+	collection := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"start": 1})
+	cursor, err := collection.Find(ctx, bson.M{"id": id}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jobs: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []job{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func (j job) activeOn(t time.Time) bool {
+	day := t.In(campusLocation).Format("2006-01-02")
+	return day >= j.Start && (j.End == "" || day <= j.End)
+}
+
+func assignJob(entry *shift, jobs []job, others []shift) []timesheetError {
+	if entry.JobId.IsZero() {
+		var active []job
+		for _, j := range jobs {
+			if j.activeOn(entry.TimeIn) {
+				active = append(active, j)
+			}
+		}
+		if len(active) == 0 {
+			return []timesheetError{{Field: "jobId", Code: "job", Message: "You don't have a job that is active on that date"}}
+		}
+		if len(active) > 1 {
+			return []timesheetError{{Field: "jobId", Code: "job", Message: "Choose the job this shift was worked for"}}
+		}
+		entry.JobId = active[0].JobId
+	}
+	for _, j := range jobs {
+		if j.JobId != entry.JobId {
+			continue
+		}
+		if !j.activeOn(entry.TimeIn) {
+			return []timesheetError{{Field: "jobId", Code: "job", Message: fmt.Sprintf("%s isn't active on that date", j.Title)}}
+		}
+		if j.AwardCap > 0 {
+			earned := entryEarnings(*entry, j)
+			for _, other := range others {
+				if other.JobId == j.JobId {
+					earned += entryEarnings(other, j)
+				}
+			}
+			if earned > j.AwardCap+0.005 {
+				return []timesheetError{{Field: "jobId", Code: "award_cap", Message: fmt.Sprintf("This shift would exceed the $%.2f award for %s", j.AwardCap, j.Title)}}
+			}
+		}
+		return nil
+	}
+	return []timesheetError{{Field: "jobId", Code: "job", Message: "Job doesn't exist"}}
+}
+
+func entryEarnings(entry shift, j job) float64 {
+	return entry.TimeOut.Sub(entry.TimeIn).Hours() * j.HourlyRate
+}
+
+func awardWarningLevel() float64 {
+	percent := appConfig.Timesheets.AwardWarningPercent
+	if percent <= 0 {
+		percent = 90
+	}
+	return percent / 100
+}
+
+func getJobEarnings(id string) ([]jobEarnings, error) {
+	jobs, err := getJobs(id)
+	if err != nil {
+		return nil, err
+	}
+	timesheet, err := getTimesheet(id)
+	if err != nil {
+		return nil, err
+	}
+	results := []jobEarnings{}
+	for _, j := range jobs {
+		earnings := jobEarnings{job: j}
+		for _, entry := range timesheet {
+			if entry.JobId == j.JobId {
+				earnings.Hours += entry.TimeOut.Sub(entry.TimeIn).Hours()
+				earnings.Earnings += entryEarnings(entry, j)
+			}
+		}
+		earnings.Earnings = math.Round(earnings.Earnings*100) / 100
+		if j.AwardCap > 0 {
+			remaining := math.Max(0, math.Round((j.AwardCap-earnings.Earnings)*100)/100)
+			earnings.Remaining = &remaining
+			earnings.Warning = earnings.Earnings >= j.AwardCap*awardWarningLevel()
+		}
+		results = append(results, earnings)
+	}
+	return results, nil
+}
+
+func checkAwardWarning(id string, jobID primitive.ObjectID) {
+	earnings, err := getJobEarnings(id)
+	if err != nil {
+		log.Printf("Error checking award for %s: %v", id, err)
+		return
+	}
+	collection := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, e := range earnings {
+		if e.JobId != jobID || !e.Warning || e.Warned {
+			continue
+		}
+		result, err := collection.UpdateOne(ctx, bson.M{"_id": jobID, "warned": bson.M{"$ne": true}}, bson.M{"$set": bson.M{"warned": true}})
+		if err != nil || result.ModifiedCount == 0 {
+			return
+		}
+		message := fmt.Sprintf("You have earned $%.2f of your $%.2f award for %s. Talk to your supervisor and financial aid before working more hours.", e.Earnings, e.AwardCap, e.Title)
+		go notifyUser(id, "Work-Study award nearly used", message, "")
+		go notifyUser(e.Supervisor, "Work-Study award nearly used", fmt.Sprintf("Student %s: %s", id, message), "")
+	}
+}
+
+func supervisedStudents(supervisor string) ([]string, error) {
+	users := dbClient.Database(dbName).Collection("users")
+	jobs := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := users.Distinct(ctx, "id", bson.M{"supervisor": supervisor})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch students: %v", err)
+	}
+	jobIds, err := jobs.Distinct(ctx, "id", bson.M{"supervisor": supervisor})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch students: %v", err)
+	}
+	seen := make(map[string]bool)
+	results := []string{}
+	for _, value := range append(ids, jobIds...) {
+		id, ok := value.(string)
+		if ok && !seen[id] {
+			seen[id] = true
+			results = append(results, id)
+		}
+	}
+	return results, nil
+}
+
+func studentSupervisors(id string) ([]string, error) {
+	users := dbClient.Database(dbName).Collection("users")
+	jobs := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := users.Distinct(ctx, "supervisor", bson.M{"id": id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch supervisors: %v", err)
+	}
+	jobIds, err := jobs.Distinct(ctx, "supervisor", bson.M{"id": id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch supervisors: %v", err)
+	}
+	seen := make(map[string]bool)
+	results := []string{}
+	for _, value := range append(ids, jobIds...) {
+		supervisor, ok := value.(string)
+		if ok && supervisor != "" && !seen[supervisor] {
+			seen[supervisor] = true
+			results = append(results, supervisor)
+		}
+	}
+	return results, nil
+}
+
+func supervises(supervisor string, id string) (bool, error) {
+	ids, err := supervisedStudents(supervisor)
+	if err != nil {
+		return false, err
+	}
+	for _, student := range ids {
+		if student == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
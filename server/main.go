@@ -8,21 +8,81 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 func main() {
+	err := loadConfig("config.json")
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	blobs, err = newBlobStore(appConfig.Storage)
+	if err != nil {
+		log.Fatalf("Error configuring record storage: %v", err)
+	}
+	err = loadRequirements("requirements.json")
+	if err != nil {
+		log.Fatalf("Error loading degree requirements: %v", err)
+	}
+	payments, err = newPaymentProvider(appConfig.Payments)
+	if err != nil {
+		log.Fatalf("Error configuring payments: %v", err)
+	}
 	connectMongoDB()
+	go expireSeatHolds()
+	go purgeDeletedRecords()
+	go closeStalePunches()
+	go sendTimesheetReminders()
+	go checkPastDueBalances()
+	go pruneNotifyLimiter()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/login", handleLogin)
 	mux.HandleFunc("/search", handleSearchClasses)
-	mux.HandleFunc("/saveTimesheet", handleSaveTimesheet)
 	mux.HandleFunc("/getTimesheet", handleGetTimesheet)
+	mux.HandleFunc("/createTimesheetEntry", handleCreateTimesheetEntry)
+	mux.HandleFunc("/updateTimesheetEntry", handleUpdateTimesheetEntry)
+	mux.HandleFunc("/deleteTimesheetEntry", handleDeleteTimesheetEntry)
+	mux.HandleFunc("/clockIn", handleClockIn)
+	mux.HandleFunc("/clockOut", handleClockOut)
+	mux.HandleFunc("/getPunch", handleGetPunch)
+	mux.HandleFunc("/getOpenPunches", handleGetOpenPunches)
+	mux.HandleFunc("/getJobs", handleGetJobs)
+	mux.HandleFunc("/saveJob", handleSaveJob)
+	mux.HandleFunc("/createPayrollBatch", handleCreatePayrollBatch)
+	mux.HandleFunc("/getPayrollBatches", handleGetPayrollBatches)
+	mux.HandleFunc("/exportPayrollBatch", handleExportPayrollBatch)
+	mux.HandleFunc("/getHousing", handleGetHousing)
+	mux.HandleFunc("/saveHousingApplication", handleSaveHousingApplication)
+	mux.HandleFunc("/getRooms", handleGetRooms)
+	mux.HandleFunc("/claimBeds", handleClaimBeds)
+	mux.HandleFunc("/releaseBed", handleReleaseBed)
+	mux.HandleFunc("/createHousingGroup", handleCreateHousingGroup)
+	mux.HandleFunc("/inviteToHousingGroup", handleInviteToHousingGroup)
+	mux.HandleFunc("/joinHousingGroup", handleJoinHousingGroup)
+	mux.HandleFunc("/leaveHousingGroup", handleLeaveHousingGroup)
+	mux.HandleFunc("/getRoommateQuestionnaire", handleGetRoommateQuestionnaire)
+	mux.HandleFunc("/saveRoommateQuestionnaire", handleSaveRoommateQuestionnaire)
+	mux.HandleFunc("/runRoommateMatching", handleRunRoommateMatching)
+	mux.HandleFunc("/getRoommateMatchRuns", handleGetRoommateMatchRuns)
+	mux.HandleFunc("/applyRoommateMatchRun", handleApplyRoommateMatchRun)
+	mux.HandleFunc("/getStatement", handleGetStatement)
+	mux.HandleFunc("/getStatementTerms", handleGetStatementTerms)
+	mux.HandleFunc("/postLedgerEntry", handlePostLedgerEntry)
+	mux.HandleFunc("/makePayment", handleMakePayment)
+	mux.HandleFunc("/refundPayment", handleRefundPayment)
+	mux.HandleFunc("/paymentWebhook", handlePaymentWebhook)
+	mux.HandleFunc("/getPayments", handleGetPayments)
+	mux.HandleFunc("/getPaymentPlans", handleGetPaymentPlans)
+	mux.HandleFunc("/enrollPaymentPlan", handleEnrollPaymentPlan)
+	mux.HandleFunc("/getDegreeAudit", handleGetDegreeAudit)
+	mux.HandleFunc("/getSBCProgress", handleGetSBCProgress)
+	mux.HandleFunc("/submitTimesheet", handleSubmitTimesheet)
+	mux.HandleFunc("/reviewTimesheet", handleReviewTimesheet)
+	mux.HandleFunc("/getPendingTimesheets", handleGetPendingTimesheets)
+	mux.HandleFunc("/reviewLateEntry", handleReviewLateEntry)
+	mux.HandleFunc("/getTimesheetPeriods", handleGetTimesheetPeriods)
 	mux.HandleFunc("/checkPrereq", handleCheckPrereq)
 	mux.HandleFunc("/saveCart", handleSaveCart)
 	mux.HandleFunc("/getCart", handleGetCart)
@@ -31,8 +91,36 @@ func main() {
 	mux.HandleFunc("/getRecords", handleGetRecords)
 	mux.HandleFunc("/getRecord", handleGetRecord)
 	mux.HandleFunc("/putRecord", handlePutRecord)
+	mux.HandleFunc("/getRecordVersions", handleGetRecordVersions)
+	mux.HandleFunc("/deleteRecord", handleDeleteRecord)
+	mux.HandleFunc("/restoreRecord", handleRestoreRecord)
+	mux.HandleFunc("/purgeRecord", handlePurgeRecord)
+	mux.HandleFunc("/getRequiredDocuments", handleGetRequiredDocuments)
+	mux.HandleFunc("/getPendingReviews", handleGetPendingReviews)
+	mux.HandleFunc("/reviewRecord", handleReviewRecord)
+	mux.HandleFunc("/getHolds", handleGetHolds)
+	mux.HandleFunc("/placeHold", handlePlaceHold)
+	mux.HandleFunc("/releaseHold", handleReleaseHold)
 	mux.HandleFunc("/getEnrollmentDate", handleGetEnrollmentDate)
 	mux.HandleFunc("/getHousingDate", handleGetHousingDate)
+	mux.HandleFunc("/generateSchedules", handleGenerateSchedules)
+	mux.HandleFunc("/downloadCalendar", handleDownloadCalendar)
+	mux.HandleFunc("/getCalendarFeed", handleGetCalendarFeed)
+	mux.HandleFunc("/calendar/", handleCalendarSubscription)
+	mux.HandleFunc("/saveDraft", handleSaveDraft)
+	mux.HandleFunc("/getDrafts", handleGetDrafts)
+	mux.HandleFunc("/deleteDraft", handleDeleteDraft)
+	mux.HandleFunc("/checkDraft", handleCheckDraft)
+	mux.HandleFunc("/compareDrafts", handleCompareDrafts)
+	mux.HandleFunc("/promoteDraft", handlePromoteDraft)
+	mux.HandleFunc("/seatEvents", handleSeatEvents)
+	mux.HandleFunc("/joinWaitlist", handleJoinWaitlist)
+	mux.HandleFunc("/leaveWaitlist", handleLeaveWaitlist)
+	mux.HandleFunc("/getWaitlists", handleGetWaitlists)
+	mux.HandleFunc("/getNotifications", handleGetNotifications)
+	mux.HandleFunc("/readNotifications", handleReadNotifications)
+	mux.HandleFunc("/getNotificationSettings", handleGetNotificationSettings)
+	mux.HandleFunc("/saveNotificationSettings", handleSaveNotificationSettings)
 	ip, err := getLocalIP()
 	if err != nil {
 		log.Fatalf("Error getting local IP address: %v", err)
@@ -65,7 +153,8 @@ func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -154,26 +243,11 @@ func handleSearchClasses(w http.ResponseWriter, r *http.Request) {
 	}
 	var responses []map[string]interface{}
 	for _, result := range results {
-		response := make(map[string]interface{})
-		if course, ok := result["course"].(bson.M); ok {
-			response["id"] = result["_id"]
-			response["class"] = course["class"]
-			response["code"] = course["code"]
-			response["credits"] = course["credits"]
-			response["title"] = course["title"]
-			response["description"] = course["description"]
-			response["prereq"] = course["prereq"]
-			response["sbc"] = course["sbc"]
-		} else {
+		response, ok := classResponse(result)
+		if !ok {
 			http.Error(w, "Invalid course data", http.StatusInternalServerError)
 			return
 		}
-		response["section"] = result["section"]
-		response["days"] = result["days"]
-		response["timeStart"] = result["timeStart"]
-		response["timeEnd"] = result["timeEnd"]
-		response["instructor"] = result["instructor"]
-		response["room"] = result["room"]
 		responses = append(responses, response)
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -183,133 +257,97 @@ func handleSearchClasses(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleSaveTimesheet(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading req body", http.StatusInternalServerError)
-		return
-	}
-	var request struct {
-		Timesheet []map[string]interface{} `json:"timesheet"`
-		Id        string                   `json:"id"`
-	}
-	err = json.Unmarshal(body, &request)
-	if err != nil {
-		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
-		return
-	}
-	var sheet []map[string]interface{}
-	for _, entry := range request.Timesheet {
-		temp := make(map[string]interface{})
-		temp["status"] = entry["status"]
-		timeIn, timeInErr := time.Parse(time.RFC3339, entry["timeIn"].(string))
-		if timeInErr != nil {
-			http.Error(w, "Error converting timeIn", http.StatusBadRequest)
-		}
-		temp["timeIn"] = timeIn
-		timeOut, timeOutErr := time.Parse(time.RFC3339, entry["timeOut"].(string))
-		if timeOutErr != nil {
-			http.Error(w, "Error converting timeOut", http.StatusBadRequest)
-		}
-		temp["timeOut"] = timeOut
-		sheet = append(sheet, temp)
-	}
-	err = updateTimesheet(sheet, request.Id)
-	if err != nil {
-		http.Error(w, "Error updating timesheet to MongoDB", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
+func classResponse(result bson.M) (map[string]interface{}, bool) {
+	response := make(map[string]interface{})
+	course, ok := result["course"].(bson.M)
+	if !ok {
+		return nil, false
+	}
+	response["id"] = result["_id"]
+	response["class"] = course["class"]
+	response["code"] = course["code"]
+	response["credits"] = course["credits"]
+	response["title"] = course["title"]
+	response["description"] = course["description"]
+	response["prereq"] = course["prereq"]
+	response["sbc"] = course["sbc"]
+	response["section"] = result["section"]
+	response["size"] = result["size"]
+	response["maxSize"] = result["maxSize"]
+	response["meetings"] = result["meetings"]
+	response["timeZone"] = campusLocation.String()
+	response["instructor"] = result["instructor"]
+	return response, true
 }
 
-func handleGetTimesheet(w http.ResponseWriter, r *http.Request) {
+func handleCheckPrereq(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
 		return
 	}
 	var request struct {
-		Id string `json:"id"`
+		Prereq string `json:"prereq"`
+		Id     string `json:"id"`
 	}
 	err = json.Unmarshal(body, &request)
 	if err != nil {
-		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		http.Error(w, "Error parsing JSON request body", http.StatusBadRequest)
 		return
 	}
-	timesheet, err := getTimesheet(request.Id)
+	message, err := checkPrereqs(request.Prereq, request.Id)
 	if err != nil {
-		http.Error(w, "Error with getting cart", http.StatusInternalServerError)
+		http.Error(w, "Error checking prerequisites", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(timesheet)
-	if err != nil {
-		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	if message != "" {
+		sendConflict(w, message)
+		return
 	}
+	w.WriteHeader(http.StatusOK)
 }
 
-func handleCheckPrereq(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
-		return
+func checkPrereqs(prereq string, id string) (string, error) {
+	if prereq == "" {
+		return "", nil
 	}
-	var request struct {
-		Prereq string `json:"prereq"`
-		Id     string `json:"id"`
-	}
-	err = json.Unmarshal(body, &request)
-	if err != nil {
-		http.Error(w, "Error parsing JSON request body", http.StatusBadRequest)
-		return
-	}
-	prereqs := strings.Split(request.Prereq, ";")
+	prereqs := strings.Split(prereq, ";")
 	for _, req := range prereqs {
 		if strings.HasPrefix(req, "major") {
-			temp, err := checkMajors(strings.Split(req, " ")[1], request.Id)
-			fmt.Println(request.Id)
-			fmt.Println(err)
+			temp, err := checkMajors(strings.Split(req, " ")[1], id)
 			if err != nil {
-				http.Error(w, "Error checking major", http.StatusInternalServerError)
-				return
+				return "", fmt.Errorf("error checking major: %v", err)
 			}
 			if !temp {
-				sendConflict(w, "You do not fit the major prerequisite of this class")
-				return
+				return "You do not fit the major prerequisite of this class", nil
 			}
 		} else if strings.HasPrefix(req, "standing") {
-			temp, err := checkStanding(strings.Split(req, " ")[1], request.Id)
+			temp, err := checkStanding(strings.Split(req, " ")[1], id)
 			if err != nil {
-				http.Error(w, "Error checking standing", http.StatusInternalServerError)
-				return
+				return "", fmt.Errorf("error checking standing: %v", err)
 			}
 			if !temp {
-				sendConflict(w, "You do not fit the standing prerequisite of this class")
-				return
+				return "You do not fit the standing prerequisite of this class", nil
 			}
 		} else if strings.HasPrefix(req, ">") {
-			temp, err := checkGrade(strings.Split(req, " ")[0][1:], strings.Split(req, " ")[1], request.Id)
+			temp, err := checkGrade(strings.Split(req, " ")[0][1:], strings.Split(req, " ")[1], id)
 			if err != nil {
-				http.Error(w, "Error checking minimum grade", http.StatusInternalServerError)
-				return
+				return "", fmt.Errorf("error checking minimum grade: %v", err)
 			}
 			if !temp {
-				sendConflict(w, "You do not fit the minimum grade prerequisite of this class")
-				return
+				return "You do not fit the minimum grade prerequisite of this class", nil
 			}
 		} else {
-			temp, err := checkGrade("D", req, request.Id)
+			temp, err := checkGrade("D", req, id)
 			if err != nil {
-				http.Error(w, "Error checking grade credit", http.StatusInternalServerError)
-				return
+				return "", fmt.Errorf("error checking grade credit: %v", err)
 			}
 			if !temp {
-				sendConflict(w, "You do not fit the class prerequisite of this class")
-				return
+				return "You do not fit the class prerequisite of this class", nil
 			}
 		}
 	}
-	w.WriteHeader(http.StatusOK)
+	return "", nil
 }
 
 func sendConflict(w http.ResponseWriter, message string) {
@@ -336,9 +374,10 @@ func handleSaveCart(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
 		return
 	}
-	var classes []string
-	for _, clas := range request.Classes {
-		classes = append(classes, clas["class"].(string)+" "+clas["code"].(string)+"-"+clas["section"].(string))
+	classes, err := classKeys(request.Classes)
+	if err != nil {
+		http.Error(w, "Invalid class data", http.StatusBadRequest)
+		return
 	}
 	err = updateCart(classes, request.Id)
 	if err != nil {
@@ -348,6 +387,20 @@ func handleSaveCart(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func classKeys(classes []map[string]interface{}) ([]string, error) {
+	var keys []string
+	for _, clas := range classes {
+		course, ok1 := clas["class"].(string)
+		code, ok2 := clas["code"].(string)
+		section, ok3 := clas["section"].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, errors.New("each class needs a class, code and section")
+		}
+		keys = append(keys, course+" "+code+"-"+section)
+	}
+	return keys, nil
+}
+
 func handleGetCart(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -369,26 +422,11 @@ func handleGetCart(w http.ResponseWriter, r *http.Request) {
 	}
 	var responses []map[string]interface{}
 	for _, result := range cart {
-		response := make(map[string]interface{})
-		if course, ok := result["course"].(bson.M); ok {
-			response["id"] = result["_id"]
-			response["class"] = course["class"]
-			response["code"] = course["code"]
-			response["credits"] = course["credits"]
-			response["title"] = course["title"]
-			response["description"] = course["description"]
-			response["prereq"] = course["prereq"]
-			response["sbc"] = course["sbc"]
-		} else {
+		response, ok := classResponse(result)
+		if !ok {
 			http.Error(w, "Invalid course data", http.StatusInternalServerError)
 			return
 		}
-		response["section"] = result["section"]
-		response["days"] = result["days"]
-		response["timeStart"] = result["timeStart"]
-		response["timeEnd"] = result["timeEnd"]
-		response["instructor"] = result["instructor"]
-		response["room"] = result["room"]
 		responses = append(responses, response)
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -412,6 +450,15 @@ func handleGetUnofficialTranscript(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
 		return
 	}
+	holds, err := blockingHolds(request.Id, "transcripts")
+	if err != nil {
+		http.Error(w, "Error checking holds", http.StatusInternalServerError)
+		return
+	}
+	if len(holds) > 0 {
+		sendConflict(w, holdsError("transcript", holds).Error())
+		return
+	}
 	transcript, err := getClasses(request.Id)
 	if err != nil {
 		http.Error(w, "Error with getting transcript", http.StatusInternalServerError)
@@ -450,103 +497,6 @@ func handleGetGPA(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleGetRecords(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading req body", http.StatusInternalServerError)
-		return
-	}
-	var request struct {
-		Id string `json:"id"`
-	}
-	err = json.Unmarshal(body, &request)
-	if err != nil {
-		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
-		return
-	}
-	files, err := os.ReadDir("./user_records/" + request.Id)
-	if err != nil {
-		http.Error(w, "Error reading directory", http.StatusInternalServerError)
-		return
-	}
-	var response []string
-	for _, file := range files {
-		response = append(response, file.Name())
-	}
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(response)
-	if err != nil {
-		http.Error(w, "Error sending response", http.StatusInternalServerError)
-	}
-}
-
-func handleGetRecord(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading req body", http.StatusInternalServerError)
-		return
-	}
-	var request struct {
-		Id       string `json:"id"`
-		Filename string `json:"filename"`
-	}
-	err = json.Unmarshal(body, &request)
-	if err != nil {
-		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
-		return
-	}
-	file, err := os.Open("./user_records/" + request.Id + "/" + request.Filename + ".pdf")
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-	defer file.Close()
-
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", "attachment")
-	w.WriteHeader(http.StatusOK)
-
-	_, err = io.Copy(w, file)
-	if err != nil {
-		http.Error(w, "Error sending file", http.StatusInternalServerError)
-		return
-	}
-}
-
-func handlePutRecord(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		http.Error(w, "Unable to parse form", http.StatusBadRequest)
-		return
-	}
-	id := r.FormValue("id")
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Unable to retrieve file", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-	filename := r.FormValue("filename")
-	if filename == "" {
-		http.Error(w, "Filename is required", http.StatusBadRequest)
-		return
-	}
-	dir := fmt.Sprintf("./user_records/%s", id)
-	filePath := filepath.Join(dir, filename+".pdf")
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
-		return
-	}
-	defer outFile.Close()
-	_, err = io.Copy(outFile, file)
-	if err != nil {
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-}
-
 func handleGetEnrollmentDate(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -567,7 +517,7 @@ func handleGetEnrollmentDate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(enrollment)
+	err = json.NewEncoder(w).Encode(inCampusZone(enrollment))
 	if err != nil {
 		http.Error(w, "Error sending response", http.StatusInternalServerError)
 	}
@@ -593,7 +543,7 @@ func handleGetHousingDate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(housing)
+	err = json.NewEncoder(w).Encode(inCampusZone(housing))
 	if err != nil {
 		http.Error(w, "Error sending response", http.StatusInternalServerError)
 	}
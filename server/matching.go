@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const matchAlgorithm = "greedy-stable-v1"
+
+type roommateQuestionnaire struct {
+	Id              string         `bson:"id" json:"id"`
+	Gender          string         `bson:"gender" json:"gender"`
+	GenderInclusive bool           `bson:"genderInclusive" json:"genderInclusive"`
+	Needs           []string       `bson:"needs" json:"needs"`
+	Answers         map[string]int `bson:"answers" json:"answers"`
+	Updated         time.Time      `bson:"updated" json:"updated"`
+}
+
+type matchPair struct {
+	Students   []string           `bson:"students" json:"students"`
+	Score      float64            `bson:"score" json:"score"`
+	Components map[string]float64 `bson:"components" json:"components"`
+}
+
+type matchUnmatched struct {
+	Id     string `bson:"id" json:"id"`
+	Reason string `bson:"reason" json:"reason"`
+}
+
+type matchRun struct {
+	RunId        primitive.ObjectID      `bson:"_id,omitempty" json:"runId"`
+	Algorithm    string                  `bson:"algorithm" json:"algorithm"`
+	Ran          time.Time               `bson:"ran" json:"ran"`
+	RanBy        string                  `bson:"ranBy" json:"ranBy"`
+	Weights      map[string]float64      `bson:"weights" json:"weights"`
+	MinScore     float64                 `bson:"minScore" json:"minScore"`
+	Conflicts    [][]string              `bson:"conflicts" json:"conflicts"`
+	Inputs       []roommateQuestionnaire `bson:"inputs" json:"inputs"`
+	InputHash    string                  `bson:"inputHash" json:"inputHash"`
+	Pairs        []matchPair             `bson:"pairs" json:"pairs"`
+	Unmatched    []matchUnmatched        `bson:"unmatched" json:"unmatched"`
+	Applied      *time.Time              `bson:"applied,omitempty" json:"applied,omitempty"`
+	AppliedBy    string                  `bson:"appliedBy,omitempty" json:"appliedBy,omitempty"`
+	Reproducible bool                    `bson:"-" json:"reproducible"`
+}
+
+type matchEdge struct {
+	a, b       string
+	score      float64
+	components map[string]float64
+}
+
+var (
+	errNoQuestionnaire = errors.New("no roommate questionnaire")
+	errNoMatchRun      = errors.New("match run not found")
+	errRunApplied      = errors.New("match run was already applied")
+)
+
+func handleGetRoommateQuestionnaire(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id string `json:"id"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	questionnaire, err := getRoommateQuestionnaire(request.Id)
+	if err == errNoQuestionnaire {
+		sendHousingResult(w, nil, map[string]interface{}{"traits": matchTraits()})
+		return
+	}
+	sendHousingResult(w, err, map[string]interface{}{"traits": matchTraits(), "questionnaire": questionnaire})
+}
+
+func handleSaveRoommateQuestionnaire(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Id            string                `json:"id"`
+		Questionnaire roommateQuestionnaire `json:"questionnaire"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	request.Questionnaire.Id = request.Id
+	questionnaire, err := saveRoommateQuestionnaire(request.Questionnaire)
+	sendHousingResult(w, err, questionnaire)
+}
+
+func handleRunRoommateMatching(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		StaffId string `json:"staffId"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	if !authorize(w, request.StaffId, "staff") {
+		return
+	}
+	run, err := runRoommateMatching(request.StaffId, time.Now())
+	sendHousingResult(w, err, run)
+}
+
+func handleGetRoommateMatchRuns(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		StaffId string `json:"staffId"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	if !authorize(w, request.StaffId, "staff") {
+		return
+	}
+	runs, err := getMatchRuns()
+	sendHousingResult(w, err, runs)
+}
+
+func handleApplyRoommateMatchRun(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		StaffId string `json:"staffId"`
+		RunId   string `json:"runId"`
+	}
+	if !readHousingRequest(w, r, &request) {
+		return
+	}
+	if !authorize(w, request.StaffId, "staff") {
+		return
+	}
+	runID, err := primitive.ObjectIDFromHex(request.RunId)
+	if err != nil {
+		http.Error(w, "Invalid run id", http.StatusBadRequest)
+		return
+	}
+	switch err := applyMatchRun(request.StaffId, runID, time.Now()); {
+	case err == errNoMatchRun:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == errRunApplied:
+		sendConflict(w, err.Error())
+	default:
+		sendHousingResult(w, err, nil)
+	}
+}
+
+func ensureMatchingIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("roommateQuestionnaires").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create roommate questionnaires index: %v", err)
+	}
+}
+
+func matchTraits() []string {
+	return sortedTraits(appConfig.Housing.Matching.Weights)
+}
+
+func sortedTraits(weights map[string]float64) []string {
+	traits := []string{}
+	for trait := range weights {
+		traits = append(traits, trait)
+	}
+	sort.Strings(traits)
+	return traits
+}
+
+func validateQuestionnaire(questionnaire roommateQuestionnaire) error {
+	if questionnaire.Gender == "" {
+		return fmt.Errorf("%w: gender is required", errInvalidHousing)
+	}
+	for _, trait := range matchTraits() {
+		answer, ok := questionnaire.Answers[trait]
+		if !ok || answer < 1 || answer > 5 {
+			return fmt.Errorf("%w: answer %s from 1 to 5", errInvalidHousing, trait)
+		}
+	}
+	for trait := range questionnaire.Answers {
+		if _, ok := appConfig.Housing.Matching.Weights[trait]; !ok {
+			return fmt.Errorf("%w: unknown question %s", errInvalidHousing, trait)
+		}
+	}
+	return nil
+}
+
+func saveRoommateQuestionnaire(questionnaire roommateQuestionnaire) (roommateQuestionnaire, error) {
+	if questionnaire.Needs == nil {
+		questionnaire.Needs = []string{}
+	}
+	sort.Strings(questionnaire.Needs)
+	err := validateQuestionnaire(questionnaire)
+	if err != nil {
+		return roommateQuestionnaire{}, err
+	}
+	collection := dbClient.Database(dbName).Collection("roommateQuestionnaires")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	questionnaire.Updated = time.Now()
+	_, err = collection.ReplaceOne(ctx, bson.M{"id": questionnaire.Id}, questionnaire, options.Replace().SetUpsert(true))
+	if err != nil {
+		return roommateQuestionnaire{}, fmt.Errorf("failed to save roommate questionnaire: %v", err)
+	}
+	return questionnaire, nil
+}
+
+func getRoommateQuestionnaire(id string) (roommateQuestionnaire, error) {
+	collection := dbClient.Database(dbName).Collection("roommateQuestionnaires")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var questionnaire roommateQuestionnaire
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&questionnaire)
+	if err == mongo.ErrNoDocuments {
+		return roommateQuestionnaire{}, errNoQuestionnaire
+	}
+	if err != nil {
+		return roommateQuestionnaire{}, fmt.Errorf("failed to fetch roommate questionnaire: %v", err)
+	}
+	return questionnaire, nil
+}
+
+func pairConstraint(a, b roommateQuestionnaire, conflicts [][]string) string {
+	if !(a.GenderInclusive && b.GenderInclusive) && a.Gender != b.Gender {
+		return "gender"
+	}
+	needs := make(map[string]bool)
+	for _, need := range a.Needs {
+		needs["a:"+need] = true
+	}
+	for _, need := range b.Needs {
+		needs["b:"+need] = true
+	}
+	for _, conflict := range conflicts {
+		if len(conflict) != 2 {
+			continue
+		}
+		if (needs["a:"+conflict[0]] && needs["b:"+conflict[1]]) || (needs["a:"+conflict[1]] && needs["b:"+conflict[0]]) {
+			return conflict[0] + "/" + conflict[1]
+		}
+	}
+	return ""
+}
+
+func compatibility(a, b roommateQuestionnaire, weights map[string]float64) (float64, map[string]float64) {
+	components := make(map[string]float64)
+	total, weightSum := 0.0, 0.0
+	for _, trait := range sortedTraits(weights) {
+		weight := weights[trait]
+		similarity := 1 - math.Abs(float64(a.Answers[trait]-b.Answers[trait]))/4
+		components[trait] = math.Round(similarity*10000) / 100
+		total += weight * similarity
+		weightSum += weight
+	}
+	if weightSum == 0 {
+		return 0, components
+	}
+	return math.Round(total/weightSum*10000) / 100, components
+}
+
+// Taking the best remaining pair first leaves no two students who would both
+// rather room together than with their match, since scores are symmetric.
+func matchRoommates(inputs []roommateQuestionnaire, weights map[string]float64, minScore float64, conflicts [][]string) ([]matchPair, []matchUnmatched) {
+	sorted := append([]roommateQuestionnaire{}, inputs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+	var edges []matchEdge
+	candidates := make(map[string]int)
+	blocked := make(map[string]string)
+	allowed := make(map[string]bool)
+	for i := range sorted {
+		for j := i + 1; j < len(sorted); j++ {
+			a, b := sorted[i], sorted[j]
+			if reason := pairConstraint(a, b, conflicts); reason != "" {
+				blocked[a.Id], blocked[b.Id] = reason, reason
+				continue
+			}
+			allowed[a.Id], allowed[b.Id] = true, true
+			score, components := compatibility(a, b, weights)
+			if score < minScore {
+				continue
+			}
+			candidates[a.Id]++
+			candidates[b.Id]++
+			edges = append(edges, matchEdge{a: a.Id, b: b.Id, score: score, components: components})
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].score != edges[j].score {
+			return edges[i].score > edges[j].score
+		}
+		if edges[i].a != edges[j].a {
+			return edges[i].a < edges[j].a
+		}
+		return edges[i].b < edges[j].b
+	})
+	matched := make(map[string]bool)
+	pairs := []matchPair{}
+	for _, edge := range edges {
+		if matched[edge.a] || matched[edge.b] {
+			continue
+		}
+		matched[edge.a], matched[edge.b] = true, true
+		pairs = append(pairs, matchPair{Students: []string{edge.a, edge.b}, Score: edge.score, Components: edge.components})
+	}
+	unmatched := []matchUnmatched{}
+	for _, student := range sorted {
+		if matched[student.Id] {
+			continue
+		}
+		reason := "no compatible student above the minimum score"
+		if candidates[student.Id] > 0 {
+			reason = "compatible students were matched with better fits"
+		} else if !allowed[student.Id] && blocked[student.Id] != "" {
+			reason = "no compatible student; hard constraint " + blocked[student.Id]
+		}
+		unmatched = append(unmatched, matchUnmatched{Id: student.Id, Reason: reason})
+	}
+	return pairs, unmatched
+}
+
+func matchInputHash(run matchRun) (string, error) {
+	data, err := json.Marshal(struct {
+		Algorithm string                  `json:"algorithm"`
+		Weights   map[string]float64      `json:"weights"`
+		MinScore  float64                 `json:"minScore"`
+		Conflicts [][]string              `json:"conflicts"`
+		Inputs    []roommateQuestionnaire `json:"inputs"`
+	}{run.Algorithm, run.Weights, run.MinScore, run.Conflicts, run.Inputs})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash match inputs: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func unpairedQuestionnaires() ([]roommateQuestionnaire, error) {
+	db := dbClient.Database(dbName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	excluded := make(map[string]bool)
+	grouped, err := db.Collection("housingGroups").Distinct(ctx, "members", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch housing groups: %v", err)
+	}
+	housed, err := db.Collection("beds").Distinct(ctx, "occupant", bson.M{"occupant": bson.M{"$type": "string"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch beds: %v", err)
+	}
+	for _, id := range append(grouped, housed...) {
+		if s, ok := id.(string); ok {
+			excluded[s] = true
+		}
+	}
+	applied, err := db.Collection("housingApplications").Distinct(ctx, "id", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch housing applications: %v", err)
+	}
+	cursor, err := db.Collection("roommateQuestionnaires").Find(ctx, bson.M{"id": bson.M{"$in": applied}},
+		options.Find().SetSort(bson.M{"id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch roommate questionnaires: %v", err)
+	}
+	var all []roommateQuestionnaire
+	if err = cursor.All(ctx, &all); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	results := []roommateQuestionnaire{}
+	for _, questionnaire := range all {
+		if !excluded[questionnaire.Id] && validateQuestionnaire(questionnaire) == nil {
+			questionnaire.Updated = questionnaire.Updated.UTC().Truncate(time.Millisecond)
+			results = append(results, questionnaire)
+		}
+	}
+	return results, nil
+}
+
+func runRoommateMatching(staff string, now time.Time) (matchRun, error) {
+	inputs, err := unpairedQuestionnaires()
+	if err != nil {
+		return matchRun{}, err
+	}
+	settings := appConfig.Housing.Matching
+	conflicts := settings.ConflictingNeeds
+	if conflicts == nil {
+		conflicts = [][]string{}
+	}
+	run := matchRun{
+		Algorithm: matchAlgorithm,
+		Ran:       now,
+		RanBy:     staff,
+		Weights:   settings.Weights,
+		MinScore:  settings.MinScore,
+		Conflicts: conflicts,
+		Inputs:    inputs,
+	}
+	run.InputHash, err = matchInputHash(run)
+	if err != nil {
+		return matchRun{}, err
+	}
+	run.Pairs, run.Unmatched = matchRoommates(run.Inputs, run.Weights, run.MinScore, run.Conflicts)
+	collection := dbClient.Database(dbName).Collection("roommateMatchRuns")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.InsertOne(ctx, run)
+	if err != nil {
+		return matchRun{}, fmt.Errorf("failed to save match run: %v", err)
+	}
+	run.RunId = result.InsertedID.(primitive.ObjectID)
+	run.Reproducible = true
+	log.Printf("Roommate match run %s by %s paired %d of %d students", run.RunId.Hex(), staff, 2*len(run.Pairs), len(inputs))
+	return run, nil
+}
+
+func verifyMatchRun(run matchRun) bool {
+	if run.Algorithm != matchAlgorithm {
+		return false
+	}
+	hash, err := matchInputHash(run)
+	if err != nil || hash != run.InputHash {
+		return false
+	}
+	pairs, unmatched := matchRoommates(run.Inputs, run.Weights, run.MinScore, run.Conflicts)
+	replayed, err := json.Marshal([]interface{}{pairs, unmatched})
+	if err != nil {
+		return false
+	}
+	stored, err := json.Marshal([]interface{}{run.Pairs, run.Unmatched})
+	return err == nil && string(replayed) == string(stored)
+}
+
+func getMatchRuns() ([]matchRun, error) {
+	collection := dbClient.Database(dbName).Collection("roommateMatchRuns")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"ran": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch match runs: %v", err)
+	}
+	results := []matchRun{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	for i := range results {
+		results[i].Reproducible = verifyMatchRun(results[i])
+	}
+	return results, nil
+}
+
+func applyMatchRun(staff string, runID primitive.ObjectID, now time.Time) error {
+	runs := dbClient.Database(dbName).Collection("roommateMatchRuns")
+	groups := dbClient.Database(dbName).Collection("housingGroups")
+	beds := dbClient.Database(dbName).Collection("beds")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	var created []matchPair
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		created = nil
+		var run matchRun
+		err := runs.FindOneAndUpdate(sc,
+			bson.M{"_id": runID, "applied": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"applied": now, "appliedBy": staff}}).Decode(&run)
+		if err == mongo.ErrNoDocuments {
+			count, err := runs.CountDocuments(sc, bson.M{"_id": runID})
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch match run: %v", err)
+			}
+			if count == 0 {
+				return nil, errNoMatchRun
+			}
+			return nil, errRunApplied
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply match run: %v", err)
+		}
+		for _, pair := range run.Pairs {
+			count, err := groups.CountDocuments(sc, bson.M{"members": bson.M{"$in": pair.Students}})
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch housing groups: %v", err)
+			}
+			if count > 0 {
+				log.Printf("Skipping matched pair %v from run %s: already in a housing group", pair.Students, runID.Hex())
+				continue
+			}
+			count, err = beds.CountDocuments(sc, bson.M{"occupant": bson.M{"$in": pair.Students}})
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch beds: %v", err)
+			}
+			if count > 0 {
+				log.Printf("Skipping matched pair %v from run %s: already assigned a bed", pair.Students, runID.Hex())
+				continue
+			}
+			group := housingGroup{Leader: pair.Students[0], Members: pair.Students, Invited: []string{}, Created: now}
+			_, err = groups.InsertOne(sc, group)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create housing group: %v", err)
+			}
+			created = append(created, pair)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pair := range created {
+		for i, id := range pair.Students {
+			other := pair.Students[1-i]
+			go notifyUser(id, "Roommate match", fmt.Sprintf("You were matched with %s (compatibility %.0f%%). You can now choose a room together.", other, pair.Score), "")
+		}
+	}
+	return nil
+}
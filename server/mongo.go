@@ -12,15 +12,17 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	dbClient  *mongo.Client
-	dbName    = "polarDB"
-	errNoUser = errors.New("user not found")
+	dbClient   *mongo.Client
+	dbName     = "polarDB"
+	errNoUser  = errors.New("user not found")
+	errNoClass = errors.New("class not found")
 )
 
 func connectMongoDB() {
@@ -42,6 +44,38 @@ func connectMongoDB() {
 	ensureCollectionExists(ctx, db, "users")
 	ensureCollectionExists(ctx, db, "courses")
 	ensureCollectionExists(ctx, db, "classes")
+	ensureCollectionExists(ctx, db, "drafts")
+	ensureCollectionExists(ctx, db, "waitlists")
+	ensureCollectionExists(ctx, db, "seatHolds")
+	ensureCollectionExists(ctx, db, "notifications")
+	ensureCollectionExists(ctx, db, "records")
+	ensureCollectionExists(ctx, db, "recordVersions")
+	ensureCollectionExists(ctx, db, "holds")
+	ensureCollectionExists(ctx, db, "timesheets")
+	ensureCollectionExists(ctx, db, "punches")
+	ensureCollectionExists(ctx, db, "jobs")
+	ensureCollectionExists(ctx, db, "payrollBatches")
+	ensureCollectionExists(ctx, db, "beds")
+	ensureCollectionExists(ctx, db, "housingApplications")
+	ensureCollectionExists(ctx, db, "housingGroups")
+	ensureCollectionExists(ctx, db, "roommateQuestionnaires")
+	ensureCollectionExists(ctx, db, "roommateMatchRuns")
+	ensureCollectionExists(ctx, db, "ledger")
+	ensureCollectionExists(ctx, db, "billingAccounts")
+	ensureCollectionExists(ctx, db, "payments")
+	ensureCollectionExists(ctx, db, "paymentEvents")
+	ensureCollectionExists(ctx, db, "paymentPlans")
+	ensureCollectionExists(ctx, db, "timesheetPeriods")
+	ensureCollectionExists(ctx, db, "timesheetReminders")
+	ensureRecordIndexes(ctx, db)
+	ensureTimesheetIndexes(ctx, db)
+	ensurePunchIndexes(ctx, db)
+	ensureReminderIndexes(ctx, db)
+	ensureHousingIndexes(ctx, db)
+	ensureMatchingIndexes(ctx, db)
+	ensureBillingIndexes(ctx, db)
+	ensurePaymentIndexes(ctx, db)
+	ensureHoldIndexes(ctx, db)
 	// Uncomment if there are updates to courses.csv
 	err = parseCSVAndInsertIntoCourses("courses.csv")
 	if err != nil {
@@ -57,6 +91,18 @@ func connectMongoDB() {
 	if err != nil {
 		log.Printf("%v", err)
 	}
+	err = parseCSVAndInsertIntoJobs("jobs.csv")
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	err = parseCSVAndInsertIntoBeds("beds.csv")
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	err = importRecordFiles()
+	if err != nil {
+		log.Printf("%v", err)
+	}
 }
 
 func ensureCollectionExists(ctx context.Context, db *mongo.Database, collectionName string) {
@@ -173,14 +219,17 @@ func parseCSVAndInsertIntoClasses(csvFilePath string) error {
 		if course != nil {
 			document["course"] = course
 		}
+		legacy := make(map[string]string)
 		for i, value := range row {
 			if i > 1 {
-				if headers[i] == "timeStart" || headers[i] == "timeEnd" {
-					time, timeErr := convertTimeToDate(value)
-					if timeErr != nil {
-						return timeErr
+				if headers[i] == "meetings" {
+					meetings, meetingsErr := parseMeetings(value)
+					if meetingsErr != nil {
+						return meetingsErr
 					}
-					document[headers[i]] = time
+					document[headers[i]] = meetings
+				} else if headers[i] == "days" || headers[i] == "timeStart" || headers[i] == "timeEnd" || headers[i] == "room" {
+					legacy[headers[i]] = value
 				} else if headers[i] == "maxSize" || headers[i] == "size" {
 					number, numberErr := strconv.Atoi(value)
 					if numberErr != nil {
@@ -192,6 +241,13 @@ func parseCSVAndInsertIntoClasses(csvFilePath string) error {
 				}
 			}
 		}
+		if _, ok := document["meetings"]; !ok && legacy["days"] != "" {
+			meetings, meetingsErr := parseMeetings(strings.Join([]string{legacy["days"], legacy["timeStart"], legacy["timeEnd"], legacy["room"]}, "|"))
+			if meetingsErr != nil {
+				return meetingsErr
+			}
+			document["meetings"] = meetings
+		}
 		documents = append(documents, document)
 	}
 	_, err = classesCollection.InsertMany(ctx, documents)
@@ -202,6 +258,59 @@ func parseCSVAndInsertIntoClasses(csvFilePath string) error {
 	return nil
 }
 
+func parseCSVAndInsertIntoJobs(csvFilePath string) error {
+	file, err := os.Open(csvFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV file: %v", err)
+	}
+	if len(rows) < 2 {
+		return fmt.Errorf("CSV file is empty or does not have a header row")
+	}
+	headers := rows[0]
+	collection := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	inserted := 0
+	for _, row := range rows[1:] {
+		if len(row) != len(headers) {
+			return fmt.Errorf("row length does not match header length: %v", row)
+		}
+		document := bson.M{}
+		var jobID primitive.ObjectID
+		for i, value := range row {
+			if headers[i] == "jobId" {
+				jobID, err = primitive.ObjectIDFromHex(value)
+				if err != nil {
+					return fmt.Errorf("invalid job id %s: %v", value, err)
+				}
+			} else if headers[i] == "hourlyRate" || headers[i] == "awardCap" {
+				number, convErr := strconv.ParseFloat(value, 64)
+				if convErr != nil {
+					return fmt.Errorf("failed to convert '%s' to a number: %v", headers[i], convErr)
+				}
+				if number != 0 {
+					document[headers[i]] = number
+				}
+			} else if value != "" {
+				document[headers[i]] = value
+			}
+		}
+		result, err := collection.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$setOnInsert": document}, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to insert job: %v", err)
+		}
+		inserted += int(result.UpsertedCount)
+	}
+	fmt.Printf("Successfully inserted %d rows into the 'jobs' collection.\n", inserted)
+	return nil
+}
+
 func parseCSVAndInsertIntoUsers(csvFilePath string) error {
 	deleteErr := deleteAllDocumentsInCollection("users")
 	if deleteErr != nil {
@@ -230,7 +339,6 @@ func parseCSVAndInsertIntoUsers(csvFilePath string) error {
 			return fmt.Errorf("row length does not match header length: %v", row)
 		}
 		document := bson.M{}
-		var userId string
 		for i, value := range row {
 			if headers[i] == "credits" || headers[i] == "gpa" {
 				credits, convErr := strconv.ParseFloat(value, 64)
@@ -246,9 +354,14 @@ func parseCSVAndInsertIntoUsers(csvFilePath string) error {
 					grades[temp[0]] = temp[1]
 				}
 				document[headers[i]] = grades
-			} else if headers[i] == "timesheet" {
-				var temp []map[string]interface{}
-				document[headers[i]] = temp
+			} else if headers[i] == "roles" {
+				roles := []string{}
+				for _, role := range strings.Split(value, ";") {
+					if role != "" {
+						roles = append(roles, role)
+					}
+				}
+				document[headers[i]] = roles
 			} else if headers[i] == "current" {
 				var temp []map[string]string
 				document[headers[i]] = temp
@@ -275,19 +388,9 @@ func parseCSVAndInsertIntoUsers(csvFilePath string) error {
 				if err != nil {
 					return fmt.Errorf("error parsing minute: %v", err)
 				}
-				document[headers[i]] = time.Date(year, time.Month(month), day, hour, minute, 0, 0, time.UTC)
+				document[headers[i]] = time.Date(year, time.Month(month), day, hour, minute, 0, 0, campusLocation)
 			} else {
 				document[headers[i]] = value
-				if headers[i] == "id" {
-					userId = value
-				}
-			}
-		}
-		if userId != "" {
-			folderPath := "./user_records/" + userId
-			err := os.MkdirAll(folderPath, os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("failed to create folder for user %s: %v", userId, err)
 			}
 		}
 		documents = append(documents, document)
@@ -300,23 +403,56 @@ func parseCSVAndInsertIntoUsers(csvFilePath string) error {
 	return nil
 }
 
-func convertTimeToDate(timeString string) (time.Time, error) {
-	const layout = "15:04"
-	parsedTime, err := time.Parse(layout, timeString)
+func parseMeetings(value string) ([]bson.M, error) {
+	var meetings []bson.M
+	for _, block := range strings.Split(value, ";") {
+		fields := strings.Split(block, "|")
+		if len(fields) != 4 && len(fields) != 6 {
+			return nil, fmt.Errorf("meeting block should be days|start|end|room[|startDate|endDate]: %s", block)
+		}
+		timeStart, err := parseWallClock(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		timeEnd, err := parseWallClock(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		if timeEnd <= timeStart {
+			return nil, fmt.Errorf("meeting block ends before it starts: %s", block)
+		}
+		meeting := bson.M{
+			"days":      fields[0],
+			"timeStart": timeStart,
+			"timeEnd":   timeEnd,
+			"room":      fields[3],
+		}
+		if len(fields) == 6 {
+			startDate, err := parseCampusDate(fields[4])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing meeting start date: %v", err)
+			}
+			endDate, err := parseCampusDate(fields[5])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing meeting end date: %v", err)
+			}
+			if endDate.Before(startDate) {
+				return nil, fmt.Errorf("meeting block date range ends before it starts: %s", block)
+			}
+			meeting["startDate"] = fields[4]
+			meeting["endDate"] = fields[5]
+		}
+		meetings = append(meetings, meeting)
+	}
+	return meetings, nil
+}
+
+func parseWallClock(value string) (string, error) {
+	parsedTime, err := time.Parse("15:04", value)
 	if err != nil {
-		return time.Time{}, err
+		return "", fmt.Errorf("error parsing time %s: %v", value, err)
 	}
-	dateWithTime := time.Date(
-		2003,
-		time.January,
-		30,
-		parsedTime.Hour(),
-		parsedTime.Minute(),
-		0,
-		0,
-		time.Now().Location(),
-	)
-	return dateWithTime, nil
+	return parsedTime.Format("15:04"), nil
 }
 
 func deleteAllDocumentsInCollection(collectionName string) error {
@@ -341,7 +477,6 @@ func searchClasses(query string) ([]bson.M, error) {
 			{"course.class": bson.M{"$regex": "(?i)" + query}},
 			{"course.code": query},
 		},
-		"size": bson.M{"$gt": 0},
 	}
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
@@ -364,7 +499,6 @@ func searchSBC(query string) ([]bson.M, error) {
 			{"course.sbc": bson.M{"$in": []string{query}}},
 			{"course.sbc": bson.M{"$regex": "(?i)" + query}},
 		},
-		"size": bson.M{"$gt": 0},
 	}
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
@@ -378,47 +512,6 @@ func searchSBC(query string) ([]bson.M, error) {
 	return results, nil
 }
 
-func updateTimesheet(timesheet []map[string]interface{}, id string) error {
-	collection := dbClient.Database(dbName).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	filter := bson.M{"id": id}
-	update := bson.M{
-		"$set": bson.M{"timesheet": timesheet},
-	}
-	result, err := collection.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return fmt.Errorf("failed to update timesheet for user with id %s: %v", id, err)
-	}
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("no user found with id %v", id)
-	}
-	if result.ModifiedCount == 0 {
-		return fmt.Errorf("timesheet could not be added to %v", id)
-	}
-	return nil
-}
-
-func getTimesheet(id string) ([]bson.M, error) {
-	collection := dbClient.Database(dbName).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	filter := bson.M{
-		"id": id,
-	}
-	var result struct {
-		Timesheet []bson.M `bson:"timesheet"`
-	}
-	err := collection.FindOne(ctx, filter).Decode(&result)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
-		}
-		return nil, fmt.Errorf("failed to fetch 'timesheet': %v", err)
-	}
-	return result.Timesheet, nil
-}
-
 func checkMajors(majors string, id string) (bool, error) {
 	collection := dbClient.Database(dbName).Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -490,6 +583,21 @@ func checkGrade(grade string, classes string, id string) (bool, error) {
 	return false, nil
 }
 
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
 func indexInArray(target string, arr []string) int {
 	for index, str := range arr {
 		if str == target {
@@ -520,20 +628,50 @@ func updateCart(classes []string, id string) error {
 	collection := dbClient.Database(dbName).Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	err := setCurrentNull(id)
+	current, err := getCurrent(id)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, clas := range classes {
+		keep[clas] = true
+	}
+	err = checkRegistrationBlocks(classes, current, id)
+	if err != nil {
+		return err
+	}
+	enrolled := make(map[string]bool)
+	for _, section := range current {
+		key := sectionKey(section)
+		enrolled[key] = true
+		if keep[key] {
+			continue
+		}
+		if sectionID, ok := section["_id"].(primitive.ObjectID); ok {
+			err = releaseClassSize(sectionID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = setCurrentNull(id)
 	if err != nil {
 		return err
 	}
 	var failed []string
 	for _, clas := range classes {
-		course := strings.Split(clas, " ")[0]
-		code := strings.Split(strings.Split(clas, " ")[1], "-")[0]
-		section := strings.Split(strings.Split(clas, " ")[1], "-")[1]
-		err := updateClassSize(course, code, section)
+		course, code, section, err := parseClassKey(clas)
 		if err != nil {
 			failed = append(failed, clas)
 			continue
 		}
+		if !enrolled[clas] {
+			err = updateClassSize(course, code, section, id)
+			if err != nil {
+				failed = append(failed, clas)
+				continue
+			}
+		}
 		temp, err := searchClass(course, code, section)
 		if err != nil {
 			failed = append(failed, clas)
@@ -566,33 +704,86 @@ func updateCart(classes []string, id string) error {
 			failed = append(failed, clas)
 		}
 	}
+	publishCart(id, classes)
+	refreshCharges(id)
 	if len(failed) > 0 {
 		return fmt.Errorf("failed to add class(es): %v", strings.Join(failed, ", "))
 	}
 	return nil
 }
 
-func updateClassSize(course string, code string, section string) error {
-	collection := dbClient.Database(dbName).Collection("classes")
+func checkRegistrationBlocks(classes []string, current []bson.M, id string) error {
+	enrolled := make(map[string]bool)
+	for _, section := range current {
+		enrolled[sectionKey(section)] = true
+	}
+	adding := false
+	for _, clas := range classes {
+		if !enrolled[clas] {
+			adding = true
+		}
+	}
+	if !adding {
+		return nil
+	}
+	holds, err := blockingHolds(id, "registration")
+	if err != nil {
+		return err
+	}
+	if len(holds) > 0 {
+		return holdsError("registration", holds)
+	}
+	return nil
+}
+
+func sectionKey(section bson.M) string {
+	course, _ := section["course"].(bson.M)
+	return fmt.Sprintf("%s %v-%v", joinClass(course["class"]), course["code"], section["section"])
+}
+
+func parseClassKey(clas string) (string, string, string, error) {
+	parts := strings.Split(clas, " ")
+	if len(parts) != 2 {
+		return "", "", "", fmt.Errorf("invalid class %s", clas)
+	}
+	codeSection := strings.Split(parts[1], "-")
+	if len(codeSection) != 2 {
+		return "", "", "", fmt.Errorf("invalid class %s", clas)
+	}
+	return parts[0], codeSection[0], codeSection[1], nil
+}
+
+func updateClassSize(course string, code string, section string, id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	filter := bson.M{
 		"course.class": strings.Split(course, "/"),
 		"course.code":  code,
 		"section":      section,
-		"size":         bson.M{"$gt": 0},
 	}
-	update := bson.M{
-		"$inc": bson.M{
-			"size": -1,
-		},
+	updated, waitlisted, err := claimSeat(ctx, filter, id)
+	if err != nil {
+		return err
 	}
-	result, err := collection.UpdateOne(ctx, filter, update)
+	publishSeats(updated)
+	if waitlisted {
+		if sectionID, ok := updated["_id"].(primitive.ObjectID); ok {
+			publishWaitlist(sectionID)
+		}
+	}
+	return nil
+}
+
+func releaseClassSize(section primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hold, err := releaseSeat(ctx, section)
 	if err != nil {
-		return fmt.Errorf("failed to update class size: %v", err)
+		return err
 	}
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("class size is already at 0 or the class does not exist")
+	publishSeatsByID([]interface{}{section})
+	if hold != nil {
+		notifySeatHold(hold)
 	}
 	return nil
 }
@@ -610,7 +801,22 @@ func searchClass(course string, code string, section string) (bson.M, error) {
 	err := collection.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("class not found")
+			return nil, errNoClass
+		}
+		return nil, fmt.Errorf("failed to search class: %v", err)
+	}
+	return result, nil
+}
+
+func searchClassByID(section primitive.ObjectID) (bson.M, error) {
+	collection := dbClient.Database(dbName).Collection("classes")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var result bson.M
+	err := collection.FindOne(ctx, bson.M{"_id": section}).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNoClass
 		}
 		return nil, fmt.Errorf("failed to search class: %v", err)
 	}
@@ -734,3 +940,23 @@ func checkLogin(id string, pass string) (bool, error) {
 	err = bcrypt.CompareHashAndPassword([]byte(result.Passhash), []byte(pass))
 	return err == nil, err
 }
+
+func getResidency(id string) (string, error) {
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"id": id,
+	}
+	var result struct {
+		Residency string `bson:"residency"`
+	}
+	err := collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", fmt.Errorf("user not found")
+		}
+		return "", fmt.Errorf("failed to fetch 'residency': %v", err)
+	}
+	return result.Residency, nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type notification struct {
+	Id      string    `bson:"id" json:"-"`
+	Subject string    `bson:"subject" json:"subject"`
+	Message string    `bson:"message" json:"message"`
+	Section string    `bson:"section,omitempty" json:"section,omitempty"`
+	Created time.Time `bson:"created" json:"created"`
+	Read    bool      `bson:"read" json:"read"`
+}
+
+type notificationSettings struct {
+	Channels []string `bson:"channels" json:"channels"`
+	Email    string   `bson:"email" json:"email"`
+	Webhook  string   `bson:"webhook" json:"webhook"`
+}
+
+type notifier interface {
+	send(settings notificationSettings, n notification) error
+}
+
+type inboxNotifier struct{}
+
+type smtpNotifier struct{}
+
+type webhookNotifier struct {
+	client *http.Client
+}
+
+type rateLimiter struct {
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+var (
+	notifiers = map[string]notifier{
+		"inbox":   inboxNotifier{},
+		"email":   smtpNotifier{},
+		"webhook": webhookNotifier{client: newWebhookClient()},
+	}
+	notifyLimiter = &rateLimiter{sent: make(map[string][]time.Time)}
+)
+
+func (inboxNotifier) send(settings notificationSettings, n notification) error {
+	collection := dbClient.Database(dbName).Collection("notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.InsertOne(ctx, n)
+	if err != nil {
+		return fmt.Errorf("failed to store notification: %v", err)
+	}
+	seats.publish(seatEvent{
+		name: "notification",
+		data: map[string]interface{}{
+			"subject": n.Subject,
+			"message": n.Message,
+			"section": n.Section,
+			"created": n.Created,
+		},
+	}, func(subscriber *seatSubscriber) bool {
+		return subscriber.id == n.Id
+	})
+	return nil
+}
+
+func (smtpNotifier) send(settings notificationSettings, n notification) error {
+	config := appConfig.Notifications.SMTP
+	if config.Host == "" {
+		return fmt.Errorf("smtp is not configured")
+	}
+	if settings.Email == "" {
+		return fmt.Errorf("no email address for user with id %s", n.Id)
+	}
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	message := "From: " + config.From + "\r\n" +
+		"To: " + settings.Email + "\r\n" +
+		"Subject: " + n.Subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + n.Message + "\r\n"
+	addr := config.Host + ":" + strconv.Itoa(config.Port)
+	err := smtp.SendMail(addr, auth, config.From, []string{settings.Email}, []byte(message))
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip))
+}
+
+// newWebhookClient checks every address after DNS resolution, so redirects
+// and hostnames that resolve to internal hosts are refused as well.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+func (notifier webhookNotifier) send(settings notificationSettings, n notification) error {
+	if settings.Webhook == "" {
+		return fmt.Errorf("no webhook for user with id %s", n.Id)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":      n.Id,
+		"subject": n.Subject,
+		"message": n.Message,
+		"section": n.Section,
+		"created": n.Created,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := notifier.client.Post(settings.Webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (limiter *rateLimiter) allow(id string) bool {
+	limit := appConfig.Notifications.RateLimit
+	if limit <= 0 {
+		return true
+	}
+	window := time.Duration(appConfig.Notifications.RateWindowMinutes) * time.Minute
+	now := time.Now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	var recent []time.Time
+	for _, sent := range limiter.sent[id] {
+		if now.Sub(sent) < window {
+			recent = append(recent, sent)
+		}
+	}
+	if len(recent) >= limit {
+		limiter.sent[id] = recent
+		return false
+	}
+	limiter.sent[id] = append(recent, now)
+	return true
+}
+
+// prune drops users with no sends left in the window so the map only holds
+// recently notified users.
+func (limiter *rateLimiter) prune(now time.Time) {
+	window := time.Duration(appConfig.Notifications.RateWindowMinutes) * time.Minute
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	for id, sent := range limiter.sent {
+		if len(sent) == 0 || now.Sub(sent[len(sent)-1]) >= window {
+			delete(limiter.sent, id)
+		}
+	}
+}
+
+func pruneNotifyLimiter() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		notifyLimiter.prune(time.Now())
+	}
+}
+
+func notifyUser(id string, subject string, message string, section string) {
+	n := notification{
+		Id:      id,
+		Subject: subject,
+		Message: message,
+		Section: section,
+		Created: time.Now(),
+	}
+	settings, err := getNotificationSettings(id)
+	if err != nil {
+		log.Printf("Error getting notification settings for %s: %v", id, err)
+	}
+	err = notifiers["inbox"].send(settings, n)
+	if err != nil {
+		log.Printf("Error notifying %s via inbox: %v", id, err)
+	}
+	var external []string
+	for _, channel := range settings.Channels {
+		if channel != "inbox" {
+			external = append(external, channel)
+		}
+	}
+	if len(external) == 0 {
+		return
+	}
+	if !notifyLimiter.allow(id) {
+		log.Printf("Rate limited notification for %s: %s", id, subject)
+		return
+	}
+	for _, channel := range external {
+		sender, ok := notifiers[channel]
+		if !ok {
+			continue
+		}
+		err := sender.send(settings, n)
+		if err != nil {
+			log.Printf("Error notifying %s via %s: %v", id, channel, err)
+		}
+	}
+}
+
+func handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	notifications, err := getNotifications(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting notifications", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(notifications)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleReadNotifications(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	err = readNotifications(request.Id)
+	if err != nil {
+		http.Error(w, "Error updating notifications", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	settings, err := getNotificationSettings(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting notification settings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(settings)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleSaveNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id       string               `json:"id"`
+		Settings notificationSettings `json:"settings"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	for _, channel := range request.Settings.Channels {
+		if _, ok := notifiers[channel]; !ok {
+			http.Error(w, "Unknown notification channel "+channel, http.StatusBadRequest)
+			return
+		}
+	}
+	if request.Settings.Webhook != "" {
+		parsed, err := url.Parse(request.Settings.Webhook)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			http.Error(w, "Webhook must be an http(s) URL", http.StatusBadRequest)
+			return
+		}
+		ip := net.ParseIP(parsed.Hostname())
+		if parsed.Hostname() == "localhost" || (ip != nil && !publicAddress(ip)) {
+			http.Error(w, "Webhook must not point to a local or private address", http.StatusBadRequest)
+			return
+		}
+	}
+	err = saveNotificationSettings(request.Id, request.Settings)
+	if err != nil {
+		http.Error(w, "Error saving notification settings", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func getNotificationSettings(id string) (notificationSettings, error) {
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"id": id,
+	}
+	var result struct {
+		Notifications notificationSettings `bson:"notifications"`
+	}
+	err := collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return notificationSettings{}, errNoUser
+		}
+		return notificationSettings{}, fmt.Errorf("failed to fetch 'notifications': %v", err)
+	}
+	if result.Notifications.Channels == nil {
+		result.Notifications.Channels = []string{"inbox"}
+	}
+	return result.Notifications, nil
+}
+
+func saveNotificationSettings(id string, settings notificationSettings) error {
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"id": id}
+	update := bson.M{
+		"$set": bson.M{"notifications": settings},
+	}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update notification settings for user with id %s: %v", id, err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoUser
+	}
+	return nil
+}
+
+func getNotifications(id string) ([]notification, error) {
+	collection := dbClient.Database(dbName).Collection("notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"created": -1}).SetLimit(100)
+	cursor, err := collection.Find(ctx, bson.M{"id": id}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notifications: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []notification{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func readNotifications(id string) error {
+	collection := dbClient.Database(dbName).Collection("notifications")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.UpdateMany(ctx, bson.M{"id": id, "read": false}, bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		return fmt.Errorf("failed to mark notifications read: %v", err)
+	}
+	return nil
+}
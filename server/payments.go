@@ -0,0 +1,884 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type payment struct {
+	PaymentId      primitive.ObjectID `bson:"_id,omitempty" json:"paymentId"`
+	Id             string             `bson:"id" json:"id"`
+	Kind           string             `bson:"kind" json:"kind"`
+	IdempotencyKey string             `bson:"idempotencyKey" json:"-"`
+	Amount         int64              `bson:"amount" json:"amountCents"`
+	Refunded       int64              `bson:"refunded" json:"refundedCents"`
+	Status         string             `bson:"status" json:"status"`
+	Failure        string             `bson:"failure,omitempty" json:"failure,omitempty"`
+	ProviderId     string             `bson:"providerId,omitempty" json:"-"`
+	RefundOf       primitive.ObjectID `bson:"refundOf,omitempty" json:"refundOf,omitempty"`
+	RequestedBy    string             `bson:"requestedBy" json:"requestedBy"`
+	Created        time.Time          `bson:"created" json:"created"`
+	Updated        time.Time          `bson:"updated" json:"updated"`
+}
+
+type installment struct {
+	Due    time.Time `bson:"due" json:"due"`
+	Amount int64     `bson:"amount" json:"amountCents"`
+	Paid   int64     `bson:"-" json:"paidCents"`
+	Status string    `bson:"-" json:"status"`
+}
+
+type paymentPlan struct {
+	Id           string        `bson:"id" json:"id"`
+	Term         string        `bson:"term" json:"term"`
+	Name         string        `bson:"name" json:"name"`
+	Total        int64         `bson:"total" json:"totalCents"`
+	Installments []installment `bson:"installments" json:"installments"`
+	Enrolled     time.Time     `bson:"enrolled" json:"enrolled"`
+}
+
+var (
+	errIdempotencyReuse = errors.New("idempotency key was used for a different request")
+	errNoPayment        = errors.New("payment not found")
+	errDuplicateEvent   = errors.New("payment event was already processed")
+	errInvalidPayment   = errors.New("invalid payment")
+	errPaymentDeclined  = errors.New("payment was declined")
+	errRefundExceeds    = errors.New("refund exceeds the refundable amount")
+	errPlanExists       = errors.New("already enrolled in a payment plan")
+	errNoPlan           = errors.New("payment plan not found")
+	billingHoldSource   = billingHoldPrefix + "past_due"
+)
+
+func idempotencyKey(r *http.Request, body string) string {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	return body
+}
+
+func sendPayment(w http.ResponseWriter, status int, p payment) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func sendPaymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidPayment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errNoPayment), errors.Is(err, errNoPlan):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errIdempotencyReuse), errors.Is(err, errPaymentDeclined), errors.Is(err, errRefundExceeds),
+		errors.Is(err, errPlanExists):
+		sendConflict(w, err.Error())
+	default:
+		http.Error(w, "Error processing payment", http.StatusInternalServerError)
+	}
+}
+
+func handleMakePayment(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id             string `json:"id"`
+		Amount         int64  `json:"amountCents"`
+		Token          string `json:"token"`
+		IdempotencyKey string `json:"idempotencyKey"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	key := idempotencyKey(r, request.IdempotencyKey)
+	if key == "" {
+		http.Error(w, "An Idempotency-Key is required", http.StatusBadRequest)
+		return
+	}
+	p, err := makePayment(request.Id, request.Amount, request.Token, key)
+	if errors.Is(err, errPaymentDeclined) {
+		sendConflict(w, fmt.Sprintf("Payment was declined: %s", p.Failure))
+		return
+	}
+	if err != nil {
+		sendPaymentError(w, err)
+		return
+	}
+	status := http.StatusCreated
+	if p.Status == "pending" {
+		status = http.StatusAccepted
+	}
+	sendPayment(w, status, p)
+}
+
+func handleRefundPayment(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId        string `json:"adminId"`
+		PaymentId      string `json:"paymentId"`
+		Amount         int64  `json:"amountCents"`
+		IdempotencyKey string `json:"idempotencyKey"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	paymentID, err := primitive.ObjectIDFromHex(request.PaymentId)
+	if err != nil {
+		http.Error(w, "Invalid payment id", http.StatusBadRequest)
+		return
+	}
+	key := idempotencyKey(r, request.IdempotencyKey)
+	if key == "" {
+		http.Error(w, "An Idempotency-Key is required", http.StatusBadRequest)
+		return
+	}
+	p, err := refundPayment(request.AdminId, paymentID, request.Amount, key)
+	if err != nil {
+		sendPaymentError(w, err)
+		return
+	}
+	sendPayment(w, http.StatusCreated, p)
+}
+
+func handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	err = processPaymentWebhook(r.Header, body)
+	if errors.Is(err, errBadSignature) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, errNoPayment) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error processing webhook", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetPayments(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	results, err := getPayments(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting payments", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleGetPaymentPlans(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	response := map[string]interface{}{"available": appConfig.Payments.Plans}
+	plan, err := getPaymentPlan(request.Id, appConfig.Term.Name)
+	if err != nil && err != errNoPlan {
+		http.Error(w, "Error with getting payment plans", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		paid, err := planPaid(plan)
+		if err != nil {
+			http.Error(w, "Error with getting payment plans", http.StatusInternalServerError)
+			return
+		}
+		response["enrolled"] = planStatus(plan, paid, time.Now())
+	}
+	due, err := pastDueAmount(request.Id, time.Now())
+	if err != nil {
+		http.Error(w, "Error with getting payment plans", http.StatusInternalServerError)
+		return
+	}
+	response["pastDueCents"] = due
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleEnrollPaymentPlan(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id   string `json:"id"`
+		Plan string `json:"plan"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	plan, err := enrollPaymentPlan(request.Id, request.Plan, time.Now())
+	if err != nil {
+		sendPaymentError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(planStatus(plan, 0, time.Now()))
+}
+
+func ensurePaymentIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("payments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "idempotencyKey", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "providerId", Value: 1}},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create payments index: %v", err)
+	}
+	_, err = db.Collection("paymentPlans").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "term", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create payment plans index: %v", err)
+	}
+}
+
+func validatePayments(config paymentsConfig) error {
+	if config.DueDate != "" {
+		if _, err := parseCampusDate(config.DueDate); err != nil {
+			return fmt.Errorf("invalid payments dueDate: %v", err)
+		}
+	}
+	for _, plan := range config.Plans {
+		if plan.Name == "" || len(plan.DueDates) == 0 {
+			return fmt.Errorf("payment plans need a name and due dates")
+		}
+		var last time.Time
+		for _, value := range plan.DueDates {
+			due, err := parseCampusDate(value)
+			if err != nil {
+				return fmt.Errorf("invalid due date for payment plan %s: %v", plan.Name, err)
+			}
+			if !due.After(last) {
+				return fmt.Errorf("payment plan %s due dates must be in order", plan.Name)
+			}
+			last = due
+		}
+	}
+	return nil
+}
+
+// createPayment records the request before calling the provider so that a
+// retry with the same key finds it instead of charging twice.
+func createPayment(p payment) (payment, bool, error) {
+	collection := dbClient.Database(dbName).Collection("payments")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.InsertOne(ctx, p)
+	if mongo.IsDuplicateKeyError(err) {
+		var existing payment
+		err = collection.FindOne(ctx, bson.M{"idempotencyKey": p.IdempotencyKey}).Decode(&existing)
+		if err != nil {
+			return payment{}, false, fmt.Errorf("failed to fetch payment: %v", err)
+		}
+		if existing.Id != p.Id || existing.Kind != p.Kind || existing.Amount != p.Amount || existing.RefundOf != p.RefundOf {
+			return payment{}, false, errIdempotencyReuse
+		}
+		return existing, false, nil
+	}
+	if err != nil {
+		return payment{}, false, fmt.Errorf("failed to record payment: %v", err)
+	}
+	p.PaymentId = result.InsertedID.(primitive.ObjectID)
+	return p, true, nil
+}
+
+func makePayment(id string, amount int64, token string, key string) (payment, error) {
+	if amount <= 0 {
+		return payment{}, fmt.Errorf("%w: amount must be positive", errInvalidPayment)
+	}
+	balance, err := getAccountBalance(id)
+	if err != nil {
+		return payment{}, err
+	}
+	now := time.Now()
+	p, created, err := createPayment(payment{Id: id, Kind: "charge", IdempotencyKey: key, Amount: amount,
+		Status: "pending", RequestedBy: id, Created: now, Updated: now})
+	if err != nil {
+		return payment{}, err
+	}
+	if created && amount > balance {
+		_, err = settlePayment(p.PaymentId, "failed", "", "amount_exceeds_balance")
+		if err != nil {
+			return payment{}, err
+		}
+		return payment{}, fmt.Errorf("%w: amount is more than the %s balance", errInvalidPayment, formatCents(balance))
+	}
+	if p.Status != "pending" || (!created && p.ProviderId != "") {
+		return paymentOutcome(p)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := payments.charge(ctx, chargeRequest{IdempotencyKey: key, Id: id, Amount: amount, Token: token,
+		Description: appConfig.Term.Name + " student account"})
+	if err != nil {
+		return payment{}, fmt.Errorf("failed to charge payment: %v", err)
+	}
+	p, err = settlePayment(p.PaymentId, result.Status, result.ProviderId, result.Failure)
+	if err != nil {
+		return payment{}, err
+	}
+	return paymentOutcome(p)
+}
+
+func paymentOutcome(p payment) (payment, error) {
+	if p.Status == "failed" {
+		return p, errPaymentDeclined
+	}
+	return p, nil
+}
+
+func refundPayment(admin string, paymentID primitive.ObjectID, amount int64, key string) (payment, error) {
+	if amount <= 0 {
+		return payment{}, fmt.Errorf("%w: amount must be positive", errInvalidPayment)
+	}
+	collection := dbClient.Database(dbName).Collection("payments")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var original payment
+	err := collection.FindOne(ctx, bson.M{"_id": paymentID, "kind": "charge"}).Decode(&original)
+	if err == mongo.ErrNoDocuments {
+		return payment{}, errNoPayment
+	}
+	if err != nil {
+		return payment{}, fmt.Errorf("failed to fetch payment: %v", err)
+	}
+	if original.Status != "succeeded" {
+		return payment{}, fmt.Errorf("%w: only settled payments can be refunded", errInvalidPayment)
+	}
+	now := time.Now()
+	p, created, err := createPayment(payment{Id: original.Id, Kind: "refund", IdempotencyKey: key, Amount: amount,
+		Status: "pending", RefundOf: paymentID, RequestedBy: admin, Created: now, Updated: now})
+	if err != nil {
+		return payment{}, err
+	}
+	if !created {
+		return p, nil
+	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": paymentID, "$expr": bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$refunded", amount}}, "$amount"}}},
+		bson.M{"$inc": bson.M{"refunded": amount}})
+	if err != nil {
+		return payment{}, fmt.Errorf("failed to reserve refund: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		_, err = settlePayment(p.PaymentId, "failed", "", "refund_exceeds_payment")
+		if err != nil {
+			return payment{}, err
+		}
+		return payment{}, errRefundExceeds
+	}
+	outcome, err := payments.refund(ctx, refundRequest{IdempotencyKey: key, ProviderId: original.ProviderId, Amount: amount})
+	if err != nil {
+		return payment{}, fmt.Errorf("failed to refund payment: %v", err)
+	}
+	p, err = settlePayment(p.PaymentId, outcome.Status, outcome.ProviderId, outcome.Failure)
+	if err != nil {
+		return payment{}, err
+	}
+	if p.Status == "failed" {
+		return p, fmt.Errorf("%w: %s", errPaymentDeclined, p.Failure)
+	}
+	return p, nil
+}
+
+func settlePayment(paymentID primitive.ObjectID, status string, providerID string, failure string) (payment, error) {
+	return settlePaymentEvent(paymentID, status, providerID, failure, nil)
+}
+
+// settlePaymentEvent moves a payment out of pending at most once, posting it
+// to the ledger and releasing a failed refund's reservation in the same
+// transaction, so replayed webhooks and retries are no-ops. A webhook event is
+// recorded in that transaction too, so a settle that fails leaves the event
+// free for the provider's retry.
+func settlePaymentEvent(paymentID primitive.ObjectID, status string, providerID string, failure string, event bson.M) (payment, error) {
+	db := dbClient.Database(dbName)
+	collection := db.Collection("payments")
+	set := bson.M{"status": status, "updated": time.Now()}
+	if providerID != "" {
+		set["providerId"] = providerID
+	}
+	if failure != "" {
+		set["failure"] = failure
+	}
+	var p payment
+	settled := false
+	err := withLedger(func(sc mongo.SessionContext) error {
+		settled = false
+		if event != nil {
+			_, err := db.Collection("paymentEvents").InsertOne(sc, event)
+			if mongo.IsDuplicateKeyError(err) {
+				return errDuplicateEvent
+			}
+			if err != nil {
+				return fmt.Errorf("failed to record payment event: %v", err)
+			}
+		}
+		filter := bson.M{"_id": paymentID, "status": "pending"}
+		if status == "pending" {
+			_, err := collection.UpdateOne(sc, filter, bson.M{"$set": set})
+			if err != nil {
+				return fmt.Errorf("failed to update payment: %v", err)
+			}
+			return collection.FindOne(sc, bson.M{"_id": paymentID}).Decode(&p)
+		}
+		err := collection.FindOneAndUpdate(sc, filter, bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&p)
+		if err == mongo.ErrNoDocuments {
+			err = collection.FindOne(sc, bson.M{"_id": paymentID}).Decode(&p)
+			if err != nil {
+				return fmt.Errorf("failed to fetch payment: %v", err)
+			}
+			settled = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update payment: %v", err)
+		}
+		// refund_exceeds_payment is set before anything was reserved.
+		if status == "failed" && p.Kind == "refund" && p.Failure != "refund_exceeds_payment" {
+			_, err = collection.UpdateOne(sc, bson.M{"_id": p.RefundOf}, bson.M{"$inc": bson.M{"refunded": -p.Amount}})
+			if err != nil {
+				return fmt.Errorf("failed to release refund: %v", err)
+			}
+		}
+		if status != "succeeded" {
+			return nil
+		}
+		entry := ledgerEntry{Id: p.Id, Term: appConfig.Term.Name, Category: "payment",
+			Source: "payment:" + p.PaymentId.Hex(), Posted: time.Now(), PostedBy: p.RequestedBy}
+		if p.Kind == "refund" {
+			entry.Kind = "refund"
+			entry.Description = "Refund"
+			entry.Amount = p.Amount
+		} else {
+			entry.Kind = "payment"
+			entry.Description = "Online payment, thank you"
+			entry.Amount = -p.Amount
+		}
+		return postLedgerEntries(sc, p.Id, []ledgerEntry{entry})
+	})
+	if err != nil {
+		return payment{}, err
+	}
+	if settled {
+		return p, nil
+	}
+	if p.Status == "succeeded" {
+		go syncFinancialHoldLogged(p.Id)
+	}
+	return p, nil
+}
+
+func processPaymentWebhook(header http.Header, body []byte) error {
+	event, err := payments.parseWebhook(header, body)
+	if err != nil {
+		return err
+	}
+	db := dbClient.Database(dbName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var p payment
+	err = db.Collection("payments").FindOne(ctx, bson.M{"providerId": event.ProviderId}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return errNoPayment
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch payment: %v", err)
+	}
+	record := bson.M{"_id": event.EventId, "type": event.Type, "paymentId": p.PaymentId, "received": time.Now()}
+	status := ""
+	switch event.Type {
+	case "charge.succeeded", "refund.succeeded":
+		status = "succeeded"
+	case "charge.failed", "refund.failed":
+		status = "failed"
+	default:
+		_, err = db.Collection("paymentEvents").InsertOne(ctx, record)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to record payment event: %v", err)
+		}
+		log.Printf("Ignoring payment event %s of type %s", event.EventId, event.Type)
+		return nil
+	}
+	p, err = settlePaymentEvent(p.PaymentId, status, "", event.Failure, record)
+	if err == errDuplicateEvent {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.Kind == "charge" {
+		if p.Status == "succeeded" {
+			go notifyUser(p.Id, "Payment received", fmt.Sprintf("Your payment of %s has posted to your account.", formatCents(p.Amount)), "")
+		} else {
+			go notifyUser(p.Id, "Payment failed", fmt.Sprintf("Your payment of %s could not be completed: %s", formatCents(p.Amount), p.Failure), "")
+		}
+	}
+	return nil
+}
+
+func getPayments(id string) ([]payment, error) {
+	collection := dbClient.Database(dbName).Collection("payments")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{"id": id}, options.Find().SetSort(bson.M{"created": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payments: %v", err)
+	}
+	results := []payment{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, abs64(cents)/100, abs64(cents)%100)
+}
+
+func findPlanConfig(name string) (paymentPlanConfig, bool) {
+	for _, plan := range appConfig.Payments.Plans {
+		if plan.Name == name {
+			return plan, true
+		}
+	}
+	return paymentPlanConfig{}, false
+}
+
+func getPaymentPlan(id string, term string) (paymentPlan, error) {
+	collection := dbClient.Database(dbName).Collection("paymentPlans")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var plan paymentPlan
+	err := collection.FindOne(ctx, bson.M{"id": id, "term": term}).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		return paymentPlan{}, errNoPlan
+	}
+	if err != nil {
+		return paymentPlan{}, fmt.Errorf("failed to fetch payment plan: %v", err)
+	}
+	return plan, nil
+}
+
+func enrollPaymentPlan(id string, name string, now time.Time) (paymentPlan, error) {
+	settings, ok := findPlanConfig(name)
+	if !ok {
+		return paymentPlan{}, errNoPlan
+	}
+	term := appConfig.Term.Name
+	current, err := getStatement(id, term)
+	if err != nil {
+		return paymentPlan{}, err
+	}
+	fee := toCents(settings.Fee)
+	total := current.Balance + fee
+	if current.Balance <= 0 {
+		return paymentPlan{}, fmt.Errorf("%w: there is no balance to pay over time", errInvalidPayment)
+	}
+	var dues []time.Time
+	for _, value := range settings.DueDates {
+		due, _ := parseCampusDate(value)
+		if !due.Before(campusDay(now)) {
+			dues = append(dues, due)
+		}
+	}
+	if len(dues) == 0 {
+		return paymentPlan{}, fmt.Errorf("%w: the %s plan has no remaining due dates", errInvalidPayment, name)
+	}
+	plan := paymentPlan{Id: id, Term: term, Name: name, Total: total, Enrolled: now}
+	share := total / int64(len(dues))
+	for i, due := range dues {
+		amount := share
+		if i == len(dues)-1 {
+			amount = total - share*int64(len(dues)-1)
+		}
+		plan.Installments = append(plan.Installments, installment{Due: due, Amount: amount})
+	}
+	collection := dbClient.Database(dbName).Collection("paymentPlans")
+	err = withLedger(func(sc mongo.SessionContext) error {
+		_, err := collection.InsertOne(sc, plan)
+		if mongo.IsDuplicateKeyError(err) {
+			return errPlanExists
+		}
+		if err != nil {
+			return fmt.Errorf("failed to enroll in payment plan: %v", err)
+		}
+		if fee == 0 {
+			return nil
+		}
+		return postLedgerEntries(sc, id, []ledgerEntry{{Id: id, Term: term, Kind: "charge", Category: "payment_plan",
+			Description: name + " payment plan fee", Amount: fee, Posted: now}})
+	})
+	if err != nil {
+		return paymentPlan{}, err
+	}
+	go syncFinancialHoldLogged(id)
+	return plan, nil
+}
+
+// planPaid totals the term's payments posted after enrollment. Earlier
+// payments were already netted out of the plan's total.
+func planPaid(plan paymentPlan) (int64, error) {
+	current, err := getStatement(plan.Id, plan.Term)
+	if err != nil {
+		return 0, err
+	}
+	var paid int64
+	for _, entry := range current.Entries {
+		if (entry.Kind == "payment" || entry.Kind == "refund") && entry.Posted.After(plan.Enrolled) {
+			paid -= entry.Amount
+		}
+	}
+	return paid, nil
+}
+
+func planStatus(plan paymentPlan, paid int64, now time.Time) paymentPlan {
+	for i := range plan.Installments {
+		item := &plan.Installments[i]
+		item.Paid = paid
+		if item.Paid > item.Amount {
+			item.Paid = item.Amount
+		}
+		paid -= item.Paid
+		switch {
+		case item.Paid >= item.Amount:
+			item.Status = "paid"
+		case now.After(item.Due.AddDate(0, 0, 1)):
+			item.Status = "past_due"
+		default:
+			item.Status = "upcoming"
+		}
+	}
+	return plan
+}
+
+// pastDueAmount treats each charge as due paymentDays after it posts, or on the
+// term due date if later, while credits apply as soon as they post. A payment
+// plan replaces that with its installments for everything posted to the term
+// before enrollment, payments included.
+func pastDueAmount(id string, now time.Time) (int64, error) {
+	collection := dbClient.Database(dbName).Collection("ledger")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{"id": id})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch ledger: %v", err)
+	}
+	var entries []ledgerEntry
+	if err = cursor.All(ctx, &entries); err != nil {
+		return 0, fmt.Errorf("failed to decode results: %v", err)
+	}
+	plan, err := getPaymentPlan(id, appConfig.Term.Name)
+	if err != nil && err != errNoPlan {
+		return 0, err
+	}
+	planned := err == nil
+	cutoff := now.AddDate(0, 0, -appConfig.Payments.GraceDays)
+	var termDue time.Time
+	if appConfig.Payments.DueDate != "" {
+		termDue, _ = parseCampusDate(appConfig.Payments.DueDate)
+	}
+	var due, paid int64
+	for _, entry := range entries {
+		if planned && entry.Term == plan.Term && !entry.Posted.After(plan.Enrolled) {
+			continue
+		}
+		switch entry.Kind {
+		case "payment", "refund":
+			paid -= entry.Amount
+			continue
+		}
+		if entry.Amount < 0 {
+			due += entry.Amount
+			continue
+		}
+		dueDate := entry.Posted.AddDate(0, 0, appConfig.Payments.PaymentDays)
+		if entry.Term == appConfig.Term.Name && termDue.After(dueDate) {
+			dueDate = termDue
+		}
+		if !dueDate.After(cutoff) {
+			due += entry.Amount
+		}
+	}
+	if planned {
+		for _, item := range plan.Installments {
+			if !item.Due.After(cutoff) {
+				due += item.Amount
+			}
+		}
+	}
+	if due > paid {
+		return due - paid, nil
+	}
+	return 0, nil
+}
+
+func syncFinancialHold(id string) error {
+	amount, err := pastDueAmount(id, time.Now())
+	if err != nil {
+		return err
+	}
+	collection := dbClient.Database(dbName).Collection("holds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var hold studentHold
+	err = collection.FindOne(ctx, bson.M{"id": id, "source": billingHoldSource, "resolved": bson.M{"$exists": false}}).Decode(&hold)
+	held := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to fetch hold: %v", err)
+	}
+	if amount == 0 {
+		if held {
+			err = resolveHold(ctx, hold.ObjectId, "system")
+			if err != nil && err != errNoHold {
+				return err
+			}
+		}
+		return nil
+	}
+	reason := fmt.Sprintf("%s is past due", formatCents(amount))
+	if held {
+		if hold.Reason == reason {
+			return nil
+		}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": hold.ObjectId}, bson.M{"$set": bson.M{"reason": reason}})
+		if err != nil {
+			return fmt.Errorf("failed to update hold: %v", err)
+		}
+		return nil
+	}
+	placed, err := upsertAutomaticHold(ctx, studentHold{
+		Id:       id,
+		Type:     "financial",
+		Source:   billingHoldSource,
+		Reason:   reason,
+		Blocks:   holdTypes["financial"],
+		PlacedBy: "system",
+		Created:  time.Now(),
+	})
+	if err != nil || !placed {
+		return err
+	}
+	go notifyUser(id, "New financial hold", fmt.Sprintf("Your student account has %s past due. Registration and transcripts are on hold until it is paid.", formatCents(amount)), "")
+	return nil
+}
+
+func syncFinancialHoldLogged(id string) {
+	err := syncFinancialHold(id)
+	if err != nil {
+		log.Printf("Error syncing financial hold for %s: %v", id, err)
+	}
+}
+
+func checkPastDueBalances() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := syncFinancialHolds()
+		if err != nil {
+			log.Printf("Error checking past due balances: %v", err)
+		}
+	}
+}
+
+func syncFinancialHolds() error {
+	db := dbClient.Database(dbName)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	owing, err := db.Collection("billingAccounts").Distinct(ctx, "id", bson.M{"balance": bson.M{"$gt": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch accounts: %v", err)
+	}
+	held, err := db.Collection("holds").Distinct(ctx, "id", bson.M{"source": billingHoldSource, "resolved": bson.M{"$exists": false}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch holds: %v", err)
+	}
+	seen := make(map[string]bool)
+	var failed []string
+	for _, value := range append(owing, held...) {
+		id, ok := value.(string)
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := syncFinancialHold(id); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", id, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type payPeriod struct {
+	Start     time.Time
+	End       time.Time
+	SubmitBy  time.Time
+	ApproveBy time.Time
+}
+
+type periodTimesheet struct {
+	Start     string    `json:"start"`
+	End       string    `json:"end"`
+	SubmitBy  time.Time `json:"submitBy"`
+	ApproveBy time.Time `json:"approveBy"`
+	Status    string    `json:"status"`
+	Locked    bool      `json:"locked"`
+	Hours     float64   `json:"hours"`
+	Earnings  float64   `json:"earnings"`
+	Entries   []shift   `json:"entries"`
+}
+
+var errNoPayPeriod = errors.New("no pay period scheduled")
+
+func (p payPeriod) key() string {
+	return p.Start.Format("2006-01-02")
+}
+
+func (p payPeriod) lastDay() string {
+	return p.End.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+func campusDay(t time.Time) time.Time {
+	local := t.In(campusLocation)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, campusLocation)
+}
+
+func payPeriodFor(t time.Time) (payPeriod, error) {
+	config := appConfig.Timesheets.PayPeriods
+	day := campusDay(t)
+	var period payPeriod
+	switch config.Schedule {
+	case "semimonthly":
+		if day.Day() <= 15 {
+			period.Start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, campusLocation)
+			period.End = time.Date(day.Year(), day.Month(), 16, 0, 0, 0, 0, campusLocation)
+		} else {
+			period.Start = time.Date(day.Year(), day.Month(), 16, 0, 0, 0, 0, campusLocation)
+			period.End = time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, campusLocation)
+		}
+	case "explicit":
+		key := day.Format("2006-01-02")
+		for _, scheduled := range config.Periods {
+			if key < scheduled.Start || key > scheduled.End {
+				continue
+			}
+			start, _ := parseCampusDate(scheduled.Start)
+			end, _ := parseCampusDate(scheduled.End)
+			period.Start = start
+			period.End = end.AddDate(0, 0, 1)
+			period.SubmitBy = deadlineOverride(scheduled.SubmitBy)
+			period.ApproveBy = deadlineOverride(scheduled.ApproveBy)
+			break
+		}
+		if period.Start.IsZero() {
+			return payPeriod{}, errNoPayPeriod
+		}
+	default:
+		anchor, err := parseCampusDate(config.Anchor)
+		if err != nil {
+			anchor, err = parseCampusDate(appConfig.Term.Start)
+		}
+		if err != nil {
+			anchor = time.Date(2025, time.January, 27, 0, 0, 0, 0, campusLocation)
+		}
+		days := int(math.Round(day.Sub(anchor).Hours() / 24))
+		if day.Before(anchor) {
+			days -= 13
+		}
+		period.Start = anchor.AddDate(0, 0, days/14*14)
+		period.End = period.Start.AddDate(0, 0, 14)
+	}
+	if period.SubmitBy.IsZero() {
+		period.SubmitBy = period.End.AddDate(0, 0, config.SubmissionDays)
+	}
+	if period.ApproveBy.IsZero() {
+		period.ApproveBy = period.SubmitBy.AddDate(0, 0, config.ApprovalDays)
+	}
+	return period, nil
+}
+
+func deadlineOverride(day string) time.Time {
+	if day == "" {
+		return time.Time{}
+	}
+	deadline, err := parseCampusDate(day)
+	if err != nil {
+		return time.Time{}
+	}
+	return deadline.AddDate(0, 0, 1)
+}
+
+func periodBounds(start string) (payPeriod, error) {
+	day, err := parseCampusDate(start)
+	if err != nil {
+		return payPeriod{}, errInvalidPeriod
+	}
+	period, err := payPeriodFor(day)
+	if err != nil || !period.Start.Equal(day) {
+		return payPeriod{}, errInvalidPeriod
+	}
+	return period, nil
+}
+
+func validatePayPeriods(config payPeriodConfig) error {
+	switch config.Schedule {
+	case "", "biweekly", "semimonthly":
+	case "explicit":
+		if len(config.Periods) == 0 {
+			return fmt.Errorf("explicit pay period schedule has no periods")
+		}
+		previous := ""
+		for _, period := range config.Periods {
+			for _, day := range []string{period.Start, period.End, period.SubmitBy, period.ApproveBy} {
+				if day == "" {
+					continue
+				}
+				if _, err := parseCampusDate(day); err != nil {
+					return fmt.Errorf("invalid pay period: %v", err)
+				}
+			}
+			if period.Start == "" || period.End < period.Start || period.Start <= previous {
+				return fmt.Errorf("pay period %s to %s is out of order", period.Start, period.End)
+			}
+			previous = period.End
+		}
+	default:
+		return fmt.Errorf("unknown pay period schedule %s", config.Schedule)
+	}
+	if config.Anchor != "" {
+		if _, err := parseCampusDate(config.Anchor); err != nil {
+			return fmt.Errorf("invalid pay period anchor: %v", err)
+		}
+	}
+	if config.SubmissionDays < 0 || config.ApprovalDays < 0 {
+		return fmt.Errorf("pay period deadlines can't be negative")
+	}
+	return nil
+}
+
+// A period stays editable until its submission deadline, or until the
+// approval deadline if the supervisor sent it back.
+func periodEditable(period payPeriod, status string, now time.Time) bool {
+	switch status {
+	case "submitted", "approved":
+		return false
+	case "rejected":
+		return now.Before(period.ApproveBy)
+	default:
+		return now.Before(period.SubmitBy)
+	}
+}
+
+func groupTimesheet(id string, entries []shift, now time.Time) ([]periodTimesheet, error) {
+	jobs, err := getJobs(id)
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[string]job)
+	for _, j := range jobs {
+		rates[j.JobId.Hex()] = j
+	}
+	statuses := make(map[string]string)
+	periods, err := getTimesheetPeriods(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, period := range periods {
+		statuses[period.Start] = period.Status
+	}
+	groups := make(map[string]*periodTimesheet)
+	var order []string
+	addPeriod := func(period payPeriod) *periodTimesheet {
+		key := period.key()
+		if group, ok := groups[key]; ok {
+			return group
+		}
+		status := statuses[key]
+		if status == "" {
+			status = "draft"
+		}
+		group := &periodTimesheet{
+			Start:     key,
+			End:       period.lastDay(),
+			SubmitBy:  period.SubmitBy,
+			ApproveBy: period.ApproveBy,
+			Status:    status,
+			Locked:    !periodEditable(period, status, now),
+			Entries:   []shift{},
+		}
+		groups[key] = group
+		order = append(order, key)
+		return group
+	}
+	if current, err := payPeriodFor(now); err == nil {
+		addPeriod(current)
+	}
+	unscheduled := &periodTimesheet{Status: "unscheduled", Locked: true, Entries: []shift{}}
+	for _, entry := range entries {
+		group := unscheduled
+		if period, err := payPeriodFor(entry.TimeIn); err == nil {
+			group = addPeriod(period)
+		}
+		hours := entry.TimeOut.Sub(entry.TimeIn).Hours()
+		group.Entries = append(group.Entries, entry)
+		group.Hours += hours
+		group.Earnings += hours * rates[entry.JobId.Hex()].HourlyRate
+	}
+	results := []periodTimesheet{}
+	for _, key := range order {
+		results = append(results, *groups[key])
+	}
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Start > results[b].Start
+	})
+	if len(unscheduled.Entries) > 0 {
+		results = append(results, *unscheduled)
+	}
+	for i := range results {
+		results[i].Hours = math.Round(results[i].Hours*100) / 100
+		results[i].Earnings = math.Round(results[i].Earnings*100) / 100
+	}
+	return results, nil
+}
+
+func sendTimesheetReminders() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := remindTimesheetDeadlines(time.Now())
+		if err != nil {
+			log.Printf("Error sending timesheet reminders: %v", err)
+		}
+	}
+}
+
+func reminderWindow() time.Duration {
+	hours := appConfig.Timesheets.PayPeriods.ReminderHours
+	if hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func remindTimesheetDeadlines(now time.Time) error {
+	window := reminderWindow()
+	period, err := payPeriodFor(now)
+	for i := 0; i < 3 && err == nil; i++ {
+		if now.Before(period.SubmitBy) && !now.Add(window).Before(period.SubmitBy) {
+			if err := remindStudents(period); err != nil {
+				return err
+			}
+		}
+		if now.Before(period.ApproveBy) && !now.Add(window).Before(period.ApproveBy) {
+			if err := remindSupervisors(period); err != nil {
+				return err
+			}
+		}
+		period, err = payPeriodFor(period.Start.AddDate(0, 0, -1))
+	}
+	return nil
+}
+
+func remindStudents(period payPeriod) error {
+	collection := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"start": bson.M{"$lte": period.lastDay()},
+		"$or": []bson.M{
+			{"end": bson.M{"$exists": false}},
+			{"end": ""},
+			{"end": bson.M{"$gte": period.key()}},
+		},
+	}
+	ids, err := collection.Distinct(ctx, "id", filter)
+	if err != nil {
+		return fmt.Errorf("failed to fetch students with jobs: %v", err)
+	}
+	for _, value := range ids {
+		id, ok := value.(string)
+		if !ok {
+			continue
+		}
+		status, err := getPeriodStatus(id, period.key())
+		if err != nil {
+			return err
+		}
+		if status != "draft" && status != "rejected" {
+			continue
+		}
+		deadline := period.SubmitBy
+		if status == "rejected" {
+			deadline = period.ApproveBy
+		}
+		first, err := claimReminder(id, period.key(), "submit")
+		if err != nil {
+			return err
+		}
+		if first {
+			message := fmt.Sprintf("Your timesheet for %s to %s is due by %s.", period.key(), period.lastDay(), deadline.In(campusLocation).Format("Jan 2 3:04 PM"))
+			go notifyUser(id, "Timesheet due soon", message, "")
+		}
+	}
+	return nil
+}
+
+func remindSupervisors(period payPeriod) error {
+	collection := dbClient.Database(dbName).Collection("timesheetPeriods")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ids, err := collection.Distinct(ctx, "id", bson.M{"start": period.key(), "status": "submitted"})
+	if err != nil {
+		return fmt.Errorf("failed to fetch submitted timesheets: %v", err)
+	}
+	waiting := make(map[string]int)
+	for _, value := range ids {
+		id, ok := value.(string)
+		if !ok {
+			continue
+		}
+		supervisors, err := studentSupervisors(id)
+		if err != nil {
+			return err
+		}
+		for _, supervisor := range supervisors {
+			waiting[supervisor]++
+		}
+	}
+	for supervisor, count := range waiting {
+		first, err := claimReminder(supervisor, period.key(), "approve")
+		if err != nil {
+			return err
+		}
+		if first {
+			message := fmt.Sprintf("%d timesheet(s) for %s to %s need your approval by %s.", count, period.key(), period.lastDay(), period.ApproveBy.In(campusLocation).Format("Jan 2 3:04 PM"))
+			go notifyUser(supervisor, "Timesheets awaiting approval", message, "")
+		}
+	}
+	return nil
+}
+
+func ensureReminderIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("timesheetReminders").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "start", Value: 1}, {Key: "kind", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create timesheet reminders index: %v", err)
+	}
+}
+
+func claimReminder(id string, start string, kind string) (bool, error) {
+	collection := dbClient.Database(dbName).Collection("timesheetReminders")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.InsertOne(ctx, bson.M{"id": id, "start": start, "kind": kind, "sent": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record reminder: %v", err)
+	}
+	return true, nil
+}
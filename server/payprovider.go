@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var errBadSignature = errors.New("invalid webhook signature")
+
+type chargeRequest struct {
+	IdempotencyKey string
+	Id             string
+	Amount         int64
+	Token          string
+	Description    string
+}
+
+type refundRequest struct {
+	IdempotencyKey string
+	ProviderId     string
+	Amount         int64
+}
+
+type providerResult struct {
+	ProviderId string
+	Status     string
+	Failure    string
+}
+
+type paymentEvent struct {
+	EventId    string `json:"eventId"`
+	Type       string `json:"type"`
+	ProviderId string `json:"providerId"`
+	Failure    string `json:"failure,omitempty"`
+}
+
+type paymentProvider interface {
+	charge(ctx context.Context, request chargeRequest) (providerResult, error)
+	refund(ctx context.Context, request refundRequest) (providerResult, error)
+	parseWebhook(header http.Header, body []byte) (paymentEvent, error)
+}
+
+type fakePaymentProvider struct {
+	mu      sync.Mutex
+	secret  string
+	delay   time.Duration
+	results map[string]providerResult
+	charges map[string]int64
+	next    int
+}
+
+var payments paymentProvider
+
+// newPaymentProvider requires the provider to be named explicitly so a
+// deployment never falls back to the fake gateway. The webhook secret comes
+// from PAYMENT_WEBHOOK_SECRET when set, so it doesn't have to live in
+// config.json.
+func newPaymentProvider(config paymentsConfig) (paymentProvider, error) {
+	secret := config.WebhookSecret
+	if env := os.Getenv("PAYMENT_WEBHOOK_SECRET"); env != "" {
+		secret = env
+	}
+	switch config.Provider {
+	case "":
+		return nil, fmt.Errorf("payments provider is required")
+	case "fake":
+		if secret == "" {
+			random := make([]byte, 32)
+			_, err := rand.Read(random)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate webhook secret: %v", err)
+			}
+			secret = hex.EncodeToString(random)
+			log.Printf("Using the fake payment provider with a generated webhook secret; cards are never really charged")
+		}
+		return &fakePaymentProvider{
+			secret:  secret,
+			delay:   2 * time.Second,
+			results: make(map[string]providerResult),
+			charges: make(map[string]int64),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %s", config.Provider)
+	}
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *fakePaymentProvider) charge(ctx context.Context, request chargeRequest) (providerResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if result, ok := p.results[request.IdempotencyKey]; ok {
+		return result, nil
+	}
+	if request.Amount <= 0 {
+		return providerResult{}, fmt.Errorf("charge amount must be positive")
+	}
+	p.next++
+	result := providerResult{ProviderId: fmt.Sprintf("fake_ch_%d_%d", time.Now().UnixNano(), p.next)}
+	switch request.Token {
+	case "tok_declined":
+		result.Status = "failed"
+		result.Failure = "card_declined"
+	case "tok_pending":
+		result.Status = "pending"
+		p.charges[result.ProviderId] = request.Amount
+		go p.deliver(paymentEvent{EventId: "evt_" + result.ProviderId, Type: "charge.succeeded", ProviderId: result.ProviderId})
+	default:
+		result.Status = "succeeded"
+		p.charges[result.ProviderId] = request.Amount
+	}
+	p.results[request.IdempotencyKey] = result
+	return result, nil
+}
+
+func (p *fakePaymentProvider) refund(ctx context.Context, request refundRequest) (providerResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if result, ok := p.results[request.IdempotencyKey]; ok {
+		return result, nil
+	}
+	result := providerResult{Status: "succeeded"}
+	charged, ok := p.charges[request.ProviderId]
+	switch {
+	case !ok:
+		result.Status = "failed"
+		result.Failure = "unknown_charge"
+	case request.Amount > charged:
+		result.Status = "failed"
+		result.Failure = "amount_exceeds_charge"
+	default:
+		p.charges[request.ProviderId] -= request.Amount
+	}
+	p.next++
+	result.ProviderId = fmt.Sprintf("fake_re_%d_%d", time.Now().UnixNano(), p.next)
+	p.results[request.IdempotencyKey] = result
+	return result, nil
+}
+
+func (p *fakePaymentProvider) parseWebhook(header http.Header, body []byte) (paymentEvent, error) {
+	expected := signWebhook(p.secret, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Payment-Signature"))) {
+		return paymentEvent{}, errBadSignature
+	}
+	var event paymentEvent
+	err := json.Unmarshal(body, &event)
+	if err != nil || event.EventId == "" || event.ProviderId == "" {
+		return paymentEvent{}, fmt.Errorf("invalid webhook payload")
+	}
+	return event, nil
+}
+
+func (p *fakePaymentProvider) deliver(event paymentEvent) {
+	time.Sleep(p.delay)
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding fake payment event: %v", err)
+		return
+	}
+	header := http.Header{}
+	header.Set("X-Payment-Signature", signWebhook(p.secret, body))
+	err = processPaymentWebhook(header, body)
+	if err != nil {
+		log.Printf("Error delivering fake payment event %s: %v", event.EventId, err)
+	}
+}
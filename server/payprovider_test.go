@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestProvider() *fakePaymentProvider {
+	return &fakePaymentProvider{
+		secret:  "test-secret",
+		delay:   time.Hour,
+		results: make(map[string]providerResult),
+		charges: make(map[string]int64),
+	}
+}
+
+func TestNewPaymentProviderRequiresExplicitProvider(t *testing.T) {
+	_, err := newPaymentProvider(paymentsConfig{})
+	if err == nil {
+		t.Fatal("empty provider was accepted")
+	}
+	_, err = newPaymentProvider(paymentsConfig{Provider: "stripe"})
+	if err == nil {
+		t.Fatal("unknown provider was accepted")
+	}
+	provider, err := newPaymentProvider(paymentsConfig{Provider: "fake"})
+	if err != nil {
+		t.Fatalf("fake provider: %v", err)
+	}
+	if provider.(*fakePaymentProvider).secret == "" {
+		t.Fatal("fake provider started without a webhook secret")
+	}
+}
+
+func TestFakeChargeIdempotentRetry(t *testing.T) {
+	p := newTestProvider()
+	ctx := context.Background()
+	request := chargeRequest{IdempotencyKey: "key-1", Id: "100000000", Amount: 5000, Token: "tok_visa"}
+	first, err := p.charge(ctx, request)
+	if err != nil || first.Status != "succeeded" {
+		t.Fatalf("charge = %+v, %v", first, err)
+	}
+	retry, err := p.charge(ctx, request)
+	if err != nil || retry != first {
+		t.Fatalf("retry = %+v, %v; want %+v", retry, err, first)
+	}
+	if len(p.charges) != 1 || p.charges[first.ProviderId] != 5000 {
+		t.Fatalf("charges after retry = %v", p.charges)
+	}
+	request.IdempotencyKey = "key-2"
+	second, err := p.charge(ctx, request)
+	if err != nil || second.ProviderId == first.ProviderId {
+		t.Fatalf("new key reused charge %+v, %v", second, err)
+	}
+
+	declined := chargeRequest{IdempotencyKey: "key-3", Amount: 5000, Token: "tok_declined"}
+	result, err := p.charge(ctx, declined)
+	if err != nil || result.Status != "failed" || result.Failure != "card_declined" {
+		t.Fatalf("declined charge = %+v, %v", result, err)
+	}
+	declined.Token = "tok_visa"
+	retried, err := p.charge(ctx, declined)
+	if err != nil || retried != result {
+		t.Fatalf("retry with the same key changed the result to %+v, %v", retried, err)
+	}
+}
+
+func TestFakeRefundLimits(t *testing.T) {
+	p := newTestProvider()
+	ctx := context.Background()
+	charge, err := p.charge(ctx, chargeRequest{IdempotencyKey: "charge", Amount: 5000, Token: "tok_visa"})
+	if err != nil {
+		t.Fatalf("charge: %v", err)
+	}
+	tests := []struct {
+		key     string
+		charge  string
+		amount  int64
+		status  string
+		failure string
+	}{
+		{"unknown", "fake_ch_missing", 100, "failed", "unknown_charge"},
+		{"too-much", charge.ProviderId, 5001, "failed", "amount_exceeds_charge"},
+		{"partial", charge.ProviderId, 3000, "succeeded", ""},
+		{"remainder-over", charge.ProviderId, 2001, "failed", "amount_exceeds_charge"},
+		{"remainder", charge.ProviderId, 2000, "succeeded", ""},
+		{"after-full", charge.ProviderId, 1, "failed", "amount_exceeds_charge"},
+	}
+	for _, test := range tests {
+		result, err := p.refund(ctx, refundRequest{IdempotencyKey: test.key, ProviderId: test.charge, Amount: test.amount})
+		if err != nil || result.Status != test.status || result.Failure != test.failure {
+			t.Errorf("refund %s = %+v, %v; want %s %s", test.key, result, err, test.status, test.failure)
+		}
+	}
+	retry, err := p.refund(ctx, refundRequest{IdempotencyKey: "partial", ProviderId: charge.ProviderId, Amount: 3000})
+	if err != nil || retry.Status != "succeeded" {
+		t.Fatalf("retried refund = %+v, %v", retry, err)
+	}
+	if p.charges[charge.ProviderId] != 0 {
+		t.Fatalf("retried refund was applied twice, %d left", p.charges[charge.ProviderId])
+	}
+}
+
+func TestFakeWebhookReplay(t *testing.T) {
+	p := newTestProvider()
+	body := []byte(`{"eventId":"evt_1","type":"charge.succeeded","providerId":"fake_ch_1"}`)
+	header := http.Header{}
+	header.Set("X-Payment-Signature", signWebhook(p.secret, body))
+	first, err := p.parseWebhook(header, body)
+	if err != nil || first.EventId != "evt_1" || first.ProviderId != "fake_ch_1" {
+		t.Fatalf("parse = %+v, %v", first, err)
+	}
+	replay, err := p.parseWebhook(header, body)
+	if err != nil || replay != first {
+		t.Fatalf("replay = %+v, %v; want the same event id so it is deduplicated", replay, err)
+	}
+
+	tampered := []byte(`{"eventId":"evt_1","type":"charge.succeeded","providerId":"fake_ch_2"}`)
+	_, err = p.parseWebhook(header, tampered)
+	if !errors.Is(err, errBadSignature) {
+		t.Fatalf("tampered body err = %v", err)
+	}
+	other := http.Header{}
+	other.Set("X-Payment-Signature", signWebhook("other-secret", body))
+	_, err = p.parseWebhook(other, body)
+	if !errors.Is(err, errBadSignature) {
+		t.Fatalf("wrong secret err = %v", err)
+	}
+	_, err = p.parseWebhook(http.Header{}, body)
+	if !errors.Is(err, errBadSignature) {
+		t.Fatalf("missing signature err = %v", err)
+	}
+
+	incomplete := []byte(`{"type":"charge.succeeded","providerId":"fake_ch_1"}`)
+	header.Set("X-Payment-Signature", signWebhook(p.secret, incomplete))
+	_, err = p.parseWebhook(header, incomplete)
+	if err == nil {
+		t.Fatal("event without an id was accepted")
+	}
+}
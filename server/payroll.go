@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type payrollLine struct {
+	Id            string             `bson:"id" json:"id"`
+	Name          string             `bson:"name" json:"name"`
+	JobId         primitive.ObjectID `bson:"jobId" json:"jobId"`
+	Title         string             `bson:"title" json:"title"`
+	Department    string             `bson:"department" json:"department"`
+	Funding       string             `bson:"funding" json:"funding"`
+	HourlyRate    float64            `bson:"hourlyRate" json:"hourlyRate"`
+	RegularHours  float64            `bson:"regularHours" json:"regularHours"`
+	OvertimeHours float64            `bson:"overtimeHours" json:"overtimeHours"`
+	Earnings      float64            `bson:"earnings" json:"earnings"`
+}
+
+type payrollBatch struct {
+	BatchId     primitive.ObjectID   `bson:"_id,omitempty" json:"batchId"`
+	PeriodStart string               `bson:"periodStart" json:"periodStart"`
+	PeriodEnd   string               `bson:"periodEnd" json:"periodEnd"`
+	CreatedBy   string               `bson:"createdBy" json:"createdBy"`
+	Created     time.Time            `bson:"created" json:"created"`
+	Entries     []primitive.ObjectID `bson:"entries" json:"entries"`
+	Lines       []payrollLine        `bson:"lines" json:"lines"`
+	Hours       float64              `bson:"hours" json:"hours"`
+	Earnings    float64              `bson:"earnings" json:"earnings"`
+}
+
+type payrollExporter interface {
+	export(w io.Writer, batch payrollBatch) error
+	contentType() string
+	extension() string
+}
+
+type csvPayrollExporter struct{}
+
+type fixedWidthPayrollExporter struct{}
+
+var (
+	errPeriodOpen      = errors.New("pay period has not closed")
+	errNothingToExport = errors.New("no approved entries to export")
+	errAlreadyExported = errors.New("entries were already exported")
+	errNoBatch         = errors.New("payroll batch not found")
+	errUnknownExporter = errors.New("unknown payroll format")
+	payrollExporters   = map[string]payrollExporter{
+		"csv":   csvPayrollExporter{},
+		"fixed": fixedWidthPayrollExporter{},
+	}
+)
+
+func (csvPayrollExporter) contentType() string { return "text/csv" }
+
+func (csvPayrollExporter) extension() string { return "csv" }
+
+func (csvPayrollExporter) export(w io.Writer, batch payrollBatch) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"batchId", "periodStart", "periodEnd", "id", "name", "jobId", "title", "department", "funding", "hourlyRate", "regularHours", "overtimeHours", "earnings"})
+	for _, line := range batch.Lines {
+		writer.Write([]string{
+			batch.BatchId.Hex(),
+			batch.PeriodStart,
+			batch.PeriodEnd,
+			line.Id,
+			line.Name,
+			line.JobId.Hex(),
+			line.Title,
+			line.Department,
+			line.Funding,
+			strconv.FormatFloat(line.HourlyRate, 'f', 2, 64),
+			strconv.FormatFloat(line.RegularHours, 'f', 2, 64),
+			strconv.FormatFloat(line.OvertimeHours, 'f', 2, 64),
+			strconv.FormatFloat(line.Earnings, 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (fixedWidthPayrollExporter) contentType() string { return "text/plain" }
+
+func (fixedWidthPayrollExporter) extension() string { return "txt" }
+
+// Each record is 135 bytes: id, name, job id, department, funding, period
+// start and end (YYYYMMDD), then rate, regular hours, overtime hours and
+// earnings as zero-padded hundredths. Text is folded to ASCII first since the
+// widths are byte counts.
+func (fixedWidthPayrollExporter) export(w io.Writer, batch payrollBatch) error {
+	start := fixedDate(batch.PeriodStart)
+	end := fixedDate(batch.PeriodEnd)
+	for _, line := range batch.Lines {
+		_, err := fmt.Fprintf(w, "%-9.9s%-25.25s%-24.24s%-20.20s%-13.13s%-8.8s%-8.8s%07d%06d%06d%09d\r\n",
+			fixedText(line.Id), fixedText(line.Name), line.JobId.Hex(), fixedText(line.Department), fixedText(line.Funding), start, end,
+			hundredths(line.HourlyRate), hundredths(line.RegularHours), hundredths(line.OvertimeHours), hundredths(line.Earnings))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var asciiFolds = strings.NewReplacer(
+	"À", "A", "Á", "A", "Â", "A", "Ã", "A", "Ä", "A", "Å", "A", "Æ", "AE", "Ç", "C",
+	"È", "E", "É", "E", "Ê", "E", "Ë", "E", "Ì", "I", "Í", "I", "Î", "I", "Ï", "I",
+	"Ñ", "N", "Ò", "O", "Ó", "O", "Ô", "O", "Õ", "O", "Ö", "O", "Ø", "O",
+	"Ù", "U", "Ú", "U", "Û", "U", "Ü", "U", "Ý", "Y", "ß", "ss",
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae", "ç", "c",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y",
+)
+
+// fixedText folds accented Latin letters to ASCII and replaces anything else
+// outside ASCII with "?", so one character is one byte.
+func fixedText(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 127 {
+			return '?'
+		}
+		return r
+	}, asciiFolds.Replace(value))
+}
+
+func fixedDate(day string) string {
+	t, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return ""
+	}
+	return t.Format("20060102")
+}
+
+func hundredths(value float64) int64 {
+	return int64(math.Round(value * 100))
+}
+
+func handleCreatePayrollBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId string `json:"adminId"`
+		Start   string `json:"start"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	batch, err := createPayrollBatch(request.Start, request.AdminId, time.Now())
+	switch {
+	case errors.Is(err, errInvalidPeriod):
+		http.Error(w, "Start must be the first day of a pay period", http.StatusBadRequest)
+		return
+	case errors.Is(err, errPeriodOpen), errors.Is(err, errNothingToExport), errors.Is(err, errAlreadyExported):
+		sendConflict(w, err.Error())
+		return
+	case err != nil:
+		http.Error(w, "Error creating payroll batch", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s created payroll batch %s for %s with %d entries", request.AdminId, batch.BatchId.Hex(), batch.PeriodStart, len(batch.Entries))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(batch)
+}
+
+func handleGetPayrollBatches(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId string `json:"adminId"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	batches, err := getPayrollBatches()
+	if err != nil {
+		http.Error(w, "Error with getting payroll batches", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(batches)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleExportPayrollBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId string `json:"adminId"`
+		BatchId string `json:"batchId"`
+		Format  string `json:"format"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	exporter, ok := payrollExporters[request.Format]
+	if !ok {
+		http.Error(w, errUnknownExporter.Error(), http.StatusBadRequest)
+		return
+	}
+	batchID, err := primitive.ObjectIDFromHex(request.BatchId)
+	if err != nil {
+		http.Error(w, "Invalid batch id", http.StatusBadRequest)
+		return
+	}
+	batch, err := getPayrollBatch(batchID)
+	if err == errNoBatch {
+		http.Error(w, "Payroll batch doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error with getting payroll batch", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", exporter.contentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"payroll-%s-%s.%s\"", batch.PeriodStart, batch.BatchId.Hex(), exporter.extension()))
+	err = exporter.export(w, batch)
+	if err != nil {
+		log.Printf("Error exporting payroll batch %s: %v", request.BatchId, err)
+	}
+}
+
+func overtimeThreshold() time.Duration {
+	return hoursLimit(appConfig.Timesheets.OvertimeWeeklyHours, 40)
+}
+
+func overtimeMultiplier() float64 {
+	if appConfig.Timesheets.OvertimeMultiplier <= 0 {
+		return 1.5
+	}
+	return appConfig.Timesheets.OvertimeMultiplier
+}
+
+// Overtime is counted per student across all of their jobs; once a week's
+// total passes the threshold, the remaining hours in that week are overtime
+// for whichever job they were worked under. exported holds the hours each
+// student/week already paid in earlier batches, which count first.
+func payrollLines(entries []shift, jobs map[primitive.ObjectID]job, names map[string]string, exported map[string]time.Duration) []payrollLine {
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Id != entries[b].Id {
+			return entries[a].Id < entries[b].Id
+		}
+		return entries[a].TimeIn.Before(entries[b].TimeIn)
+	})
+	threshold := overtimeThreshold()
+	weeks := make(map[string]time.Duration)
+	for week, worked := range exported {
+		weeks[week] = worked
+	}
+	lines := make(map[string]*payrollLine)
+	var order []string
+	for _, entry := range entries {
+		j := jobs[entry.JobId]
+		key := entry.Id + "/" + entry.JobId.Hex()
+		line, ok := lines[key]
+		if !ok {
+			line = &payrollLine{
+				Id:         entry.Id,
+				Name:       names[entry.Id],
+				JobId:      entry.JobId,
+				Title:      j.Title,
+				Department: j.Department,
+				Funding:    j.Funding,
+				HourlyRate: j.HourlyRate,
+			}
+			lines[key] = line
+			order = append(order, key)
+		}
+		week := entry.Id + "/" + weekStart(entry.TimeIn)
+		worked := entry.TimeOut.Sub(entry.TimeIn)
+		regular := worked
+		if weeks[week]+worked > threshold {
+			regular = max(0, threshold-weeks[week])
+		}
+		weeks[week] += worked
+		line.RegularHours += regular.Hours()
+		line.OvertimeHours += (worked - regular).Hours()
+	}
+	results := []payrollLine{}
+	for _, key := range order {
+		line := lines[key]
+		line.RegularHours = math.Round(line.RegularHours*100) / 100
+		line.OvertimeHours = math.Round(line.OvertimeHours*100) / 100
+		line.Earnings = math.Round((line.RegularHours*line.HourlyRate+line.OvertimeHours*line.HourlyRate*overtimeMultiplier())*100) / 100
+		results = append(results, *line)
+	}
+	return results
+}
+
+func createPayrollBatch(start string, by string, now time.Time) (payrollBatch, error) {
+	period, err := periodBounds(start)
+	if err != nil {
+		return payrollBatch{}, err
+	}
+	if now.Before(period.ApproveBy) {
+		return payrollBatch{}, errPeriodOpen
+	}
+	timesheets := dbClient.Database(dbName).Collection("timesheets")
+	batches := dbClient.Database(dbName).Collection("payrollBatches")
+	users := dbClient.Database(dbName).Collection("users")
+	jobs := dbClient.Database(dbName).Collection("jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"timeIn":  bson.M{"$gte": period.Start, "$lt": period.End},
+		"status":  entryStatuses["approved"],
+		"batchId": bson.M{"$exists": false},
+	}
+	cursor, err := timesheets.Find(ctx, filter)
+	if err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to fetch approved entries: %v", err)
+	}
+	var entries []shift
+	if err = cursor.All(ctx, &entries); err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to decode results: %v", err)
+	}
+	if len(entries) == 0 {
+		return payrollBatch{}, errNothingToExport
+	}
+	var ids []string
+	var jobIds []primitive.ObjectID
+	var entryIds []primitive.ObjectID
+	for _, entry := range entries {
+		ids = append(ids, entry.Id)
+		jobIds = append(jobIds, entry.JobId)
+		entryIds = append(entryIds, entry.EntryId)
+	}
+	cursor, err = users.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to fetch students: %v", err)
+	}
+	var students []struct {
+		Id    string `bson:"id"`
+		First string `bson:"first"`
+		Last  string `bson:"last"`
+	}
+	if err = cursor.All(ctx, &students); err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to decode results: %v", err)
+	}
+	names := make(map[string]string)
+	for _, student := range students {
+		names[student.Id] = student.First + " " + student.Last
+	}
+	cursor, err = jobs.Find(ctx, bson.M{"_id": bson.M{"$in": jobIds}})
+	if err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to fetch jobs: %v", err)
+	}
+	var jobList []job
+	if err = cursor.All(ctx, &jobList); err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to decode results: %v", err)
+	}
+	jobsById := make(map[primitive.ObjectID]job)
+	for _, j := range jobList {
+		jobsById[j.JobId] = j
+	}
+	exported, err := exportedWeekHours(ctx, entries)
+	if err != nil {
+		return payrollBatch{}, err
+	}
+	batch := payrollBatch{
+		BatchId:     primitive.NewObjectID(),
+		PeriodStart: period.key(),
+		PeriodEnd:   period.lastDay(),
+		CreatedBy:   by,
+		Created:     now,
+		Entries:     entryIds,
+		Lines:       payrollLines(entries, jobsById, names, exported),
+	}
+	for _, line := range batch.Lines {
+		batch.Hours += line.RegularHours + line.OvertimeHours
+		batch.Earnings += line.Earnings
+	}
+	batch.Hours = math.Round(batch.Hours*100) / 100
+	batch.Earnings = math.Round(batch.Earnings*100) / 100
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		result, err := timesheets.UpdateMany(sc,
+			bson.M{"_id": bson.M{"$in": entryIds}, "batchId": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"batchId": batch.BatchId, "updated": now}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark exported entries: %v", err)
+		}
+		if result.ModifiedCount != int64(len(entryIds)) {
+			return nil, errAlreadyExported
+		}
+		_, err = batches.InsertOne(sc, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert payroll batch: %v", err)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return payrollBatch{}, err
+	}
+	return batch, nil
+}
+
+// exportedWeekHours totals the approved hours that earlier batches already
+// exported for the students and weeks that entries fall in. Weeks can span
+// pay periods, and late approvals can land in a second batch for a period.
+func exportedWeekHours(ctx context.Context, entries []shift) (map[string]time.Duration, error) {
+	exported := make(map[string]time.Duration)
+	if len(entries) == 0 {
+		return exported, nil
+	}
+	weeks := make(map[string]bool)
+	var ids []string
+	earliest, latest := entries[0].TimeIn, entries[0].TimeIn
+	for _, entry := range entries {
+		weeks[entry.Id+"/"+weekStart(entry.TimeIn)] = true
+		ids = append(ids, entry.Id)
+		earliest = minTime(earliest, entry.TimeIn)
+		latest = maxTime(latest, entry.TimeIn)
+	}
+	from, err := time.ParseInLocation("2006-01-02", weekStart(earliest), campusLocation)
+	if err != nil {
+		return nil, err
+	}
+	to, err := time.ParseInLocation("2006-01-02", weekStart(latest), campusLocation)
+	if err != nil {
+		return nil, err
+	}
+	timesheets := dbClient.Database(dbName).Collection("timesheets")
+	cursor, err := timesheets.Find(ctx, bson.M{
+		"id":      bson.M{"$in": ids},
+		"timeIn":  bson.M{"$gte": from, "$lt": to.AddDate(0, 0, 7)},
+		"status":  entryStatuses["approved"],
+		"batchId": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exported entries: %v", err)
+	}
+	var paid []shift
+	if err = cursor.All(ctx, &paid); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, entry := range paid {
+		week := entry.Id + "/" + weekStart(entry.TimeIn)
+		if weeks[week] {
+			exported[week] += entry.TimeOut.Sub(entry.TimeIn)
+		}
+	}
+	return exported, nil
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func getPayrollBatch(batchID primitive.ObjectID) (payrollBatch, error) {
+	collection := dbClient.Database(dbName).Collection("payrollBatches")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var batch payrollBatch
+	err := collection.FindOne(ctx, bson.M{"_id": batchID}).Decode(&batch)
+	if err == mongo.ErrNoDocuments {
+		return payrollBatch{}, errNoBatch
+	}
+	if err != nil {
+		return payrollBatch{}, fmt.Errorf("failed to fetch payroll batch: %v", err)
+	}
+	return batch, nil
+}
+
+func getPayrollBatches() ([]payrollBatch, error) {
+	collection := dbClient.Database(dbName).Collection("payrollBatches")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"created": -1}).SetProjection(bson.M{"lines": 0})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payroll batches: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []payrollBatch{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
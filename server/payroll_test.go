@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFixedWidthExportMultibyteNames(t *testing.T) {
+	batch := payrollBatch{
+		PeriodStart: "2026-09-01",
+		PeriodEnd:   "2026-09-14",
+		Lines: []payrollLine{
+			{Id: "100000001", Name: "José Núñez", JobId: primitive.NewObjectID(), Department: "Biblioteca Central", Funding: "F-001",
+				HourlyRate: 15.5, RegularHours: 20, Earnings: 310},
+			{Id: "100000002", Name: "Zoë Åström-Müllerson Kowalczyk", JobId: primitive.NewObjectID(), Department: "Library", Funding: "F-002",
+				HourlyRate: 16, RegularHours: 40, OvertimeHours: 2.5, Earnings: 700},
+			{Id: "100000003", Name: "王小明", JobId: primitive.NewObjectID(), Department: "Café", Funding: "F-003",
+				HourlyRate: 15, RegularHours: 10, Earnings: 150},
+		},
+	}
+	var out bytes.Buffer
+	err := fixedWidthPayrollExporter{}.export(&out, batch)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+	if len(lines) != len(batch.Lines) {
+		t.Fatalf("got %d records, want %d", len(lines), len(batch.Lines))
+	}
+	names := []string{"Jose Nunez", "Zoe Astrom-Mullerson Kowa", "???"}
+	for i, line := range lines {
+		if len(line) != 135 {
+			t.Errorf("record %d is %d bytes, want 135: %q", i, len(line), line)
+			continue
+		}
+		if got := strings.TrimRight(line[9:34], " "); got != names[i] {
+			t.Errorf("record %d name = %q, want %q", i, got, names[i])
+		}
+		if got := line[99:107]; got != "20260914" {
+			t.Errorf("record %d period end = %q, want 20260914", i, got)
+		}
+	}
+	if got := strings.TrimRight(lines[2][58:78], " "); got != "Cafe" {
+		t.Errorf("department = %q, want Cafe", got)
+	}
+}
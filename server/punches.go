@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type punch struct {
+	ObjectId primitive.ObjectID `bson:"_id,omitempty" json:"punchId"`
+	Id       string             `bson:"id" json:"id"`
+	JobId    primitive.ObjectID `bson:"jobId,omitempty" json:"jobId,omitempty"`
+	ClockIn  time.Time          `bson:"clockIn" json:"clockIn"`
+}
+
+var (
+	errOpenPunch = errors.New("already clocked in")
+	errNoPunch   = errors.New("not clocked in")
+)
+
+func handleClockIn(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id    string `json:"id"`
+		JobId string `json:"jobId"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	var jobID primitive.ObjectID
+	if request.JobId != "" {
+		jobID, err = primitive.ObjectIDFromHex(request.JobId)
+		if err != nil {
+			http.Error(w, "Invalid job id", http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now()
+	jobs, err := getJobs(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting jobs", http.StatusInternalServerError)
+		return
+	}
+	check := shift{Id: request.Id, TimeIn: now, TimeOut: now, JobId: jobID}
+	if errs := assignJob(&check, jobs, nil); len(errs) > 0 {
+		sendTimesheetErrors(w, errs)
+		return
+	}
+	p, err := clockIn(request.Id, check.JobId, now)
+	if err == errOpenPunch {
+		sendConflict(w, "You are already clocked in")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error clocking in", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+func handleClockOut(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	p, err := getOpenPunch(request.Id)
+	if err == errNoPunch {
+		sendConflict(w, "You are not clocked in. Add the shift to your timesheet and your supervisor will review it.")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error clocking out", http.StatusInternalServerError)
+		return
+	}
+	entry, err := closePunch(p, time.Now(), nil)
+	if err == errNoPunch {
+		sendConflict(w, "This punch was already closed")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error clocking out", http.StatusInternalServerError)
+		return
+	}
+	sendEntry(w, http.StatusOK, entry)
+}
+
+func handleGetPunch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	var open *punch
+	p, err := getOpenPunch(request.Id)
+	if err == nil {
+		open = &p
+	} else if err != errNoPunch {
+		http.Error(w, "Error with getting punch", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(open)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleGetOpenPunches(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		SupervisorId string `json:"supervisorId"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.SupervisorId, "supervisor") {
+		return
+	}
+	open, err := getOpenPunches(request.SupervisorId)
+	if err != nil {
+		http.Error(w, "Error with getting open punches", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(open)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func ensurePunchIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("punches").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create punches index: %v", err)
+	}
+}
+
+func clockIn(id string, jobID primitive.ObjectID, now time.Time) (punch, error) {
+	collection := dbClient.Database(dbName).Collection("punches")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p := punch{Id: id, JobId: jobID, ClockIn: now}
+	result, err := collection.InsertOne(ctx, p)
+	if mongo.IsDuplicateKeyError(err) {
+		return punch{}, errOpenPunch
+	}
+	if err != nil {
+		return punch{}, fmt.Errorf("failed to clock in user with id %s: %v", id, err)
+	}
+	p.ObjectId = result.InsertedID.(primitive.ObjectID)
+	return p, nil
+}
+
+func getOpenPunch(id string) (punch, error) {
+	collection := dbClient.Database(dbName).Collection("punches")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var p punch
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return punch{}, errNoPunch
+	}
+	if err != nil {
+		return punch{}, fmt.Errorf("failed to fetch punch: %v", err)
+	}
+	return p, nil
+}
+
+func closePunch(p punch, out time.Time, flags []string) (shift, error) {
+	maxShift := hoursLimit(appConfig.Timesheets.MaxShiftHours, 6)
+	if out.Sub(p.ClockIn) > maxShift {
+		out = p.ClockIn.Add(maxShift)
+		flags = append(flags, "auto_closed")
+	}
+	entry := shift{Id: p.Id, TimeIn: p.ClockIn, TimeOut: out, JobId: p.JobId, Source: "punch"}
+	existing, err := getTimesheet(p.Id)
+	if err != nil {
+		return shift{}, err
+	}
+	jobs, err := getJobs(p.Id)
+	if err != nil {
+		return shift{}, err
+	}
+	for _, problem := range append(assignJob(&entry, jobs, existing), validateEntry(entry, existing)...) {
+		flags = append(flags, problem.Code)
+	}
+	locked, err := entryLocked(entry)
+	if err != nil {
+		return shift{}, err
+	}
+	if locked {
+		entry.Status = entryStatuses["late"]
+		flags = append(flags, "period_locked")
+	}
+	entry.Flags = flags
+	punches := dbClient.Database(dbName).Collection("punches")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return shift{}, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		result, err := punches.DeleteOne(sc, bson.M{"_id": p.ObjectId})
+		if err != nil {
+			return nil, fmt.Errorf("failed to close punch: %v", err)
+		}
+		if result.DeletedCount == 0 {
+			return nil, errNoPunch
+		}
+		entry, err = createTimesheetEntry(sc, entry)
+		return nil, err
+	})
+	if err != nil {
+		return shift{}, err
+	}
+	go checkAwardWarning(entry.Id, entry.JobId)
+	return entry, nil
+}
+
+func closeStalePunches() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := closeExpiredPunches(time.Now())
+		if err != nil {
+			log.Printf("Error closing stale punches: %v", err)
+		}
+	}
+}
+
+func closeExpiredPunches(now time.Time) error {
+	collection := dbClient.Database(dbName).Collection("punches")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	maxShift := hoursLimit(appConfig.Timesheets.MaxShiftHours, 6)
+	cursor, err := collection.Find(ctx, bson.M{"clockIn": bson.M{"$lte": now.Add(-maxShift)}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch open punches: %v", err)
+	}
+	var stale []punch
+	if err = cursor.All(ctx, &stale); err != nil {
+		return fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, p := range stale {
+		entry, err := closePunch(p, now, []string{"missed_clock_out"})
+		if err == errNoPunch {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		message := fmt.Sprintf("You didn't clock out after clocking in at %s. Your shift was closed at %s; correct it on your timesheet if needed.",
+			entry.TimeIn.In(campusLocation).Format("Jan 2 3:04 PM"), entry.TimeOut.In(campusLocation).Format("3:04 PM"))
+		go notifyUser(p.Id, "Missed clock-out", message, "")
+		log.Printf("Auto-closed punch for %s started at %s", p.Id, p.ClockIn.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func getOpenPunches(supervisor string) ([]map[string]interface{}, error) {
+	users := dbClient.Database(dbName).Collection("users")
+	punches := dbClient.Database(dbName).Collection("punches")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := supervisedStudents(supervisor)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := users.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch students: %v", err)
+	}
+	var students []struct {
+		Id    string `bson:"id"`
+		First string `bson:"first"`
+		Last  string `bson:"last"`
+	}
+	if err = cursor.All(ctx, &students); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	names := make(map[string]string)
+	for _, student := range students {
+		names[student.Id] = student.First + " " + student.Last
+	}
+	open := []map[string]interface{}{}
+	if len(ids) == 0 {
+		return open, nil
+	}
+	cursor, err = punches.Find(ctx, bson.M{"id": bson.M{"$in": ids}}, options.Find().SetSort(bson.M{"clockIn": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch open punches: %v", err)
+	}
+	var results []punch
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, p := range results {
+		open = append(open, map[string]interface{}{
+			"id":      p.Id,
+			"name":    names[p.Id],
+			"clockIn": p.ClockIn,
+			"hours":   time.Since(p.ClockIn).Hours(),
+		})
+	}
+	return open, nil
+}
@@ -0,0 +1,831 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	recordNamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _(),'.-]{0,99}$`)
+	recordOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	errInvalidRecord   = errors.New("invalid record name")
+	errRecordExists    = errors.New("record already exists")
+	errRecordTooLarge  = errors.New("record is too large")
+	errQuotaExceeded   = errors.New("record storage quota exceeded")
+	errNotPDF          = errors.New("record is not a PDF")
+	errNoRecord        = errors.New("record not found")
+)
+
+type recordVersion struct {
+	ObjectId    primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Owner       string             `bson:"owner" json:"-"`
+	Name        string             `bson:"name" json:"name"`
+	Version     int                `bson:"version" json:"version"`
+	Key         string             `bson:"key" json:"-"`
+	Size        int64              `bson:"size" json:"size"`
+	Checksum    string             `bson:"checksum" json:"checksum"`
+	ContentType string             `bson:"contentType" json:"contentType"`
+	Uploader    string             `bson:"uploader" json:"uploader"`
+	Uploaded    time.Time          `bson:"uploaded" json:"uploaded"`
+	Status      string             `bson:"status" json:"status"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	Reviewer    string             `bson:"reviewer,omitempty" json:"reviewer,omitempty"`
+	Reviewed    *time.Time         `bson:"reviewed,omitempty" json:"reviewed,omitempty"`
+}
+
+type recordMeta struct {
+	recordVersion `bson:",inline"`
+	Deleted       *time.Time `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	DeletedBy     string     `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	PurgeAfter    *time.Time `bson:"purgeAfter,omitempty" json:"purgeAfter,omitempty"`
+}
+
+func handleGetRecords(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id      string `json:"id"`
+		Deleted bool   `json:"deleted"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !recordOwnerPattern.MatchString(request.Id) {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	records, err := getRecords(request.Id, request.Deleted)
+	if err != nil {
+		http.Error(w, "Error with getting records", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(records)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id       string `json:"id"`
+		Filename string `json:"filename"`
+		Version  int    `json:"version"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !validRecord(request.Id, request.Filename) {
+		http.Error(w, "Invalid record name", http.StatusBadRequest)
+		return
+	}
+	current, err := findRecord(request.Id, request.Filename)
+	if err == errNoRecord || (err == nil && current.Deleted != nil) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error with getting record", http.StatusInternalServerError)
+		return
+	}
+	record := current.recordVersion
+	if request.Version != 0 && request.Version != record.Version {
+		record, err = findRecordVersion(request.Id, request.Filename, request.Version)
+		if err == errNoRecord {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error with getting record", http.StatusInternalServerError)
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	blob, err := blobs.get(ctx, record.Key)
+	if err == errNoBlob {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", record.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": record.Name + ".pdf"}))
+	w.Header().Set("Content-Length", strconv.FormatInt(record.Size, 10))
+	w.Header().Set("ETag", strconv.Quote(record.Checksum))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		log.Printf("Error streaming record %s for %s: %v", record.Name, record.Owner, err)
+	}
+}
+
+func handleGetRecordVersions(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id       string `json:"id"`
+		Filename string `json:"filename"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !validRecord(request.Id, request.Filename) {
+		http.Error(w, "Invalid record name", http.StatusBadRequest)
+		return
+	}
+	current, err := findRecord(request.Id, request.Filename)
+	if err == errNoRecord || (err == nil && current.Deleted != nil) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error with getting record", http.StatusInternalServerError)
+		return
+	}
+	versions, err := getRecordVersions(request.Id, request.Filename)
+	if err != nil {
+		http.Error(w, "Error with getting record versions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(versions)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleDeleteRecord(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id       string `json:"id"`
+		Filename string `json:"filename"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !validRecord(request.Id, request.Filename) {
+		http.Error(w, "Invalid record name", http.StatusBadRequest)
+		return
+	}
+	record, err := deleteRecord(request.Id, request.Filename, request.Id)
+	if err == errNoRecord {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deleting record", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(record)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func handleRestoreRecord(w http.ResponseWriter, r *http.Request) {
+	handleAdminRecord(w, r, restoreRecord)
+}
+
+func handlePurgeRecord(w http.ResponseWriter, r *http.Request) {
+	handleAdminRecord(w, r, purgeRecord)
+}
+
+func handleAdminRecord(w http.ResponseWriter, r *http.Request, action func(id string, name string) error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		AdminId  string `json:"adminId"`
+		Id       string `json:"id"`
+		Filename string `json:"filename"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, request.AdminId, "admin") {
+		return
+	}
+	if !validRecord(request.Id, request.Filename) {
+		http.Error(w, "Invalid record name", http.StatusBadRequest)
+		return
+	}
+	err = action(request.Id, request.Filename)
+	if err == errNoRecord {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating record %s for %s: %v", request.Filename, request.Id, err)
+		http.Error(w, "Error updating record", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handlePutRecord(w http.ResponseWriter, r *http.Request) {
+	maxUpload := maxRecordSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload+(1<<20))
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	fields := map[string]string{}
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "Unable to retrieve file", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, fmt.Sprintf("Records can be at most %d MB", maxUpload>>20), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			break
+		}
+		value, err := io.ReadAll(io.LimitReader(part, 1024))
+		if err != nil {
+			http.Error(w, "Unable to parse form", http.StatusBadRequest)
+			return
+		}
+		fields[part.FormName()] = string(value)
+	}
+	defer part.Close()
+	id := fields["id"]
+	filename := fields["filename"]
+	if filename == "" {
+		http.Error(w, "Filename is required", http.StatusBadRequest)
+		return
+	}
+	if contentType := part.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/pdf" {
+			http.Error(w, "Records must be PDF files", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	uploader := id
+	if staffId := fields["staffId"]; staffId != "" {
+		if !authorize(w, staffId, "staff") {
+			return
+		}
+		uploader = staffId
+	}
+	overwrite := fields["overwrite"] == "true"
+	err = saveRecord(id, filename, uploader, part, overwrite)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errInvalidRecord):
+		http.Error(w, "Invalid record name", http.StatusBadRequest)
+	case errors.Is(err, errNotPDF):
+		http.Error(w, "Records must be PDF files", http.StatusUnsupportedMediaType)
+	case errors.Is(err, errRecordTooLarge), errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("Records can be at most %d MB", maxUpload>>20), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errQuotaExceeded):
+		http.Error(w, fmt.Sprintf("Record storage is limited to %d MB per student", recordQuota()>>20), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errRecordExists):
+		sendConflict(w, "A record named "+filename+" already exists")
+	case err != nil:
+		log.Printf("Error saving record %s for %s: %v", filename, id, err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func recordsRoot() string {
+	if appConfig.Records.Root == "" {
+		return "./user_records"
+	}
+	return appConfig.Records.Root
+}
+
+func maxRecordSize() int64 {
+	if appConfig.Records.MaxUploadMB <= 0 {
+		return 10 << 20
+	}
+	return appConfig.Records.MaxUploadMB << 20
+}
+
+func recordQuota() int64 {
+	if appConfig.Records.QuotaMB <= 0 {
+		return 50 << 20
+	}
+	return appConfig.Records.QuotaMB << 20
+}
+
+func pathWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func validRecord(id string, name string) bool {
+	return recordOwnerPattern.MatchString(id) && recordNamePattern.MatchString(name) && !strings.Contains(name, "..")
+}
+
+func isPDF(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("%PDF-")) && http.DetectContentType(head) == "application/pdf"
+}
+
+func ensureRecordIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("records").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create records index: %v", err)
+	}
+	_, err = db.Collection("recordVersions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create record versions index: %v", err)
+	}
+}
+
+func getRecords(id string, deleted bool) ([]recordMeta, error) {
+	collection := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := collection.Find(ctx, bson.M{"owner": id, "deleted": bson.M{"$exists": deleted}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch records: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []recordMeta{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func findRecord(id string, name string) (recordMeta, error) {
+	collection := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var record recordMeta
+	err := collection.FindOne(ctx, bson.M{"owner": id, "name": name}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return recordMeta{}, errNoRecord
+	}
+	if err != nil {
+		return recordMeta{}, fmt.Errorf("failed to fetch record %s: %v", name, err)
+	}
+	return record, nil
+}
+
+func getRecordVersions(id string, name string) ([]recordVersion, error) {
+	collection := dbClient.Database(dbName).Collection("recordVersions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"version": -1})
+	cursor, err := collection.Find(ctx, bson.M{"owner": id, "name": name}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch record versions: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []recordVersion{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func findRecordVersion(id string, name string, version int) (recordVersion, error) {
+	collection := dbClient.Database(dbName).Collection("recordVersions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var record recordVersion
+	err := collection.FindOne(ctx, bson.M{"owner": id, "name": name, "version": version}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return recordVersion{}, errNoRecord
+	}
+	if err != nil {
+		return recordVersion{}, fmt.Errorf("failed to fetch version %d of record %s: %v", version, name, err)
+	}
+	return record, nil
+}
+
+func recordKeyExists(key string) (bool, error) {
+	collection := dbClient.Database(dbName).Collection("recordVersions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := collection.CountDocuments(ctx, bson.M{"key": key})
+	if err != nil {
+		return false, fmt.Errorf("failed to look up record %s: %v", key, err)
+	}
+	return count > 0, nil
+}
+
+// recordUsage counts every stored version of the student's records, including
+// soft-deleted ones, until they are purged.
+func recordUsage(id string) (int64, error) {
+	collection := dbClient.Database(dbName).Collection("recordVersions")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"owner": id}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute record usage: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return 0, fmt.Errorf("failed to decode results: %v", err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Total, nil
+}
+
+func spoolRecord(src io.Reader, limit int64, limitErr error) (*os.File, int64, string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, 0, "", err
+	}
+	head = head[:n]
+	if !isPDF(head) {
+		return nil, 0, "", errNotPDF
+	}
+	tmp, err := os.CreateTemp("", "polar-upload-*")
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to create temp file: %v", err)
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), src), limit+1))
+	if err == nil && written > limit {
+		err = limitErr
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, "", err
+	}
+	return tmp, written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func saveRecord(id string, name string, uploader string, src io.Reader, overwrite bool) error {
+	if !validRecord(id, name) {
+		return errInvalidRecord
+	}
+	version := 1
+	existing, err := findRecord(id, name)
+	if err == nil {
+		if existing.Deleted == nil && !overwrite {
+			return errRecordExists
+		}
+		version = existing.Version + 1
+	} else if err != errNoRecord {
+		return err
+	}
+	usage, err := recordUsage(id)
+	if err != nil {
+		return err
+	}
+	limit := maxRecordSize()
+	limitErr := errRecordTooLarge
+	if remaining := recordQuota() - usage; remaining < limit {
+		limit = remaining
+		limitErr = errQuotaExceeded
+	}
+	tmp, size, checksum, err := spoolRecord(src, limit, limitErr)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	record := recordVersion{
+		Owner:       id,
+		Name:        name,
+		Version:     version,
+		Key:         id + "/" + primitive.NewObjectID().Hex() + ".pdf",
+		Size:        size,
+		Checksum:    checksum,
+		ContentType: "application/pdf",
+		Uploader:    uploader,
+		Uploaded:    time.Now(),
+		Status:      "pending",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	err = blobs.put(ctx, record.Key, tmp, record.Size, record.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed to store record: %v", err)
+	}
+	err = addRecordVersion(ctx, record)
+	if err != nil {
+		blobs.delete(ctx, record.Key)
+		return err
+	}
+	return nil
+}
+
+func addRecordVersion(ctx context.Context, record recordVersion) error {
+	versions := dbClient.Database(dbName).Collection("recordVersions")
+	records := dbClient.Database(dbName).Collection("records")
+	_, err := versions.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return errRecordExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert record version: %v", err)
+	}
+	opts := options.Replace().SetUpsert(true)
+	_, err = records.ReplaceOne(ctx, bson.M{"owner": record.Owner, "name": record.Name}, recordMeta{recordVersion: record}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to update record metadata: %v", err)
+	}
+	return nil
+}
+
+func importRecordFiles() error {
+	root := recordsRoot()
+	owners, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read records directory: %v", err)
+	}
+	imported := 0
+	for _, owner := range owners {
+		if !owner.IsDir() || !recordOwnerPattern.MatchString(owner.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(root, owner.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read records for %s: %v", owner.Name(), err)
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), ".pdf")
+			if !file.Type().IsRegular() || filepath.Ext(file.Name()) != ".pdf" || !validRecord(owner.Name(), name) {
+				continue
+			}
+			known, err := recordKeyExists(owner.Name() + "/" + file.Name())
+			if err != nil {
+				return err
+			}
+			if known {
+				continue
+			}
+			err = importRecordFile(filepath.Join(root, owner.Name(), file.Name()), owner.Name(), name)
+			if err != nil {
+				return err
+			}
+			imported++
+		}
+	}
+	if imported > 0 {
+		fmt.Printf("Imported %d existing records into the 'records' collection.\n", imported)
+	}
+	return nil
+}
+
+func importRecordFile(path string, id string, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open record %s: %v", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat record %s: %v", path, err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to read record %s: %v", path, err)
+	}
+	record := recordVersion{
+		Owner:       id,
+		Name:        name,
+		Version:     1,
+		Key:         id + "/" + name + ".pdf",
+		Size:        info.Size(),
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		ContentType: "application/pdf",
+		Uploader:    id,
+		Uploaded:    info.ModTime(),
+		Status:      "pending",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	stored, err := blobs.exists(ctx, record.Key)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		err = blobs.put(ctx, record.Key, file, record.Size, record.ContentType)
+		if err != nil {
+			return fmt.Errorf("failed to store record %s: %v", path, err)
+		}
+	}
+	err = addRecordVersion(ctx, record)
+	if err != nil && err != errRecordExists {
+		return err
+	}
+	return nil
+}
+
+func recordRetention() time.Duration {
+	if appConfig.Records.RetentionDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(appConfig.Records.RetentionDays) * 24 * time.Hour
+}
+
+func deleteRecord(id string, name string, by string) (recordMeta, error) {
+	collection := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	purgeAfter := now.Add(recordRetention())
+	filter := bson.M{"owner": id, "name": name, "deleted": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set": bson.M{"deleted": now, "deletedBy": by, "purgeAfter": purgeAfter},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var record recordMeta
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return recordMeta{}, errNoRecord
+	}
+	if err != nil {
+		return recordMeta{}, fmt.Errorf("failed to delete record %s: %v", name, err)
+	}
+	return record, nil
+}
+
+func restoreRecord(id string, name string) error {
+	collection := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"owner": id, "name": name, "deleted": bson.M{"$exists": true}}
+	update := bson.M{
+		"$unset": bson.M{"deleted": "", "deletedBy": "", "purgeAfter": ""},
+	}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to restore record %s: %v", name, err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoRecord
+	}
+	return nil
+}
+
+// purgeRecord only removes a soft-deleted record and the versions uploaded
+// before it was deleted, so a record re-uploaded since is left alone.
+func purgeRecord(id string, name string) error {
+	records := dbClient.Database(dbName).Collection("records")
+	versions := dbClient.Database(dbName).Collection("recordVersions")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	filter := bson.M{"owner": id, "name": name, "deleted": bson.M{"$exists": true}}
+	var record recordMeta
+	err := records.FindOne(ctx, filter).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return errNoRecord
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch record %s: %v", name, err)
+	}
+	cursor, err := versions.Find(ctx, bson.M{"owner": id, "name": name, "uploaded": bson.M{"$lte": record.Deleted}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch versions of record %s: %v", name, err)
+	}
+	var history []recordVersion
+	if err = cursor.All(ctx, &history); err != nil {
+		return fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, version := range history {
+		err = blobs.delete(ctx, version.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete version %d of record %s: %v", version.Version, name, err)
+		}
+		_, err = versions.DeleteOne(ctx, bson.M{"_id": version.ObjectId})
+		if err != nil {
+			return fmt.Errorf("failed to purge version %d of record %s: %v", version.Version, name, err)
+		}
+	}
+	_, err = records.DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to purge record %s: %v", name, err)
+	}
+	return nil
+}
+
+func purgeDeletedRecords() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := purgeExpiredRecords()
+		if err != nil {
+			log.Printf("Error purging deleted records: %v", err)
+		}
+	}
+}
+
+func purgeExpiredRecords() error {
+	collection := dbClient.Database(dbName).Collection("records")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{"purgeAfter": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch expired records: %v", err)
+	}
+	var expired []recordMeta
+	if err = cursor.All(ctx, &expired); err != nil {
+		return fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, record := range expired {
+		err = purgeRecord(record.Owner, record.Name)
+		if err != nil && err != errNoRecord {
+			log.Printf("Error purging record %s for %s: %v", record.Name, record.Owner, err)
+		}
+	}
+	return nil
+}
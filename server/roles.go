@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func hasRole(id string, role string) (bool, error) {
+	collection := dbClient.Database(dbName).Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := collection.CountDocuments(ctx, bson.M{"id": id, "roles": role})
+	if err != nil {
+		return false, fmt.Errorf("failed to check roles for user with id %s: %v", id, err)
+	}
+	return count > 0, nil
+}
+
+func authorize(w http.ResponseWriter, id string, role string) bool {
+	ok, err := hasRole(id, role)
+	if err != nil {
+		http.Error(w, "Error checking permissions", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Requires the "+role+" role", http.StatusForbidden)
+		return false
+	}
+	return true
+}
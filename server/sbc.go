@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type sbcCategory struct {
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`
+	Count    int      `json:"count"`
+	Distinct int      `json:"distinct"`
+	MinGrade string   `json:"minGrade"`
+}
+
+type sbcRules struct {
+	MinGrade   string        `json:"minGrade"`
+	Categories []sbcCategory `json:"categories"`
+}
+
+type sbcSuggestion struct {
+	Section    string   `json:"section"`
+	Title      string   `json:"title"`
+	SBC        []string `json:"sbc"`
+	Instructor string   `json:"instructor"`
+	Seats      int      `json:"seats"`
+	Fills      []string `json:"fills"`
+}
+
+type sbcCategoryProgress struct {
+	Id          string         `json:"id"`
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	Needed      int            `json:"needed"`
+	Completed   int            `json:"completed"`
+	InProgress  int            `json:"inProgress"`
+	Areas       []string       `json:"areas,omitempty"`
+	CoursesUsed []courseRecord `json:"coursesUsed"`
+	BelowGrade  []courseRecord `json:"belowGrade,omitempty"`
+	Missing     []string       `json:"missing,omitempty"`
+	Suggestions []string       `json:"suggestions,omitempty"`
+}
+
+type sbcProgress struct {
+	Term        string                `json:"term"`
+	Satisfied   int                   `json:"satisfied"`
+	Categories  []sbcCategoryProgress `json:"categories"`
+	Suggestions []sbcSuggestion       `json:"suggestions"`
+}
+
+func validateSBC(rules sbcRules) error {
+	if rules.MinGrade != "" && indexInArray(rules.MinGrade, letterGrades) < 0 {
+		return fmt.Errorf("sbc has unknown minimum grade %s", rules.MinGrade)
+	}
+	seen := make(map[string]bool)
+	for _, category := range rules.Categories {
+		if category.Id == "" || seen[category.Id] {
+			return fmt.Errorf("sbc has a category with a missing or duplicate id")
+		}
+		seen[category.Id] = true
+		if len(category.Tags) == 0 || category.Count <= 0 {
+			return fmt.Errorf("sbc category %s needs tags and a positive count", category.Id)
+		}
+		if category.Distinct > len(category.Tags) || category.Distinct > category.Count {
+			return fmt.Errorf("sbc category %s requires more distinct tags than it can use", category.Id)
+		}
+		if category.MinGrade != "" && indexInArray(category.MinGrade, letterGrades) < 0 {
+			return fmt.Errorf("sbc category %s has unknown minimum grade %s", category.Id, category.MinGrade)
+		}
+	}
+	return nil
+}
+
+func handleGetSBCProgress(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	progress, err := getSBCProgress(request.Id)
+	if err != nil {
+		http.Error(w, "Error with fetching SBC progress", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(progress)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+// categoryTag returns the first of the category's tags the course carries.
+// Tags match exactly, so a "+" tag such as SBS+ only counts toward
+// categories that list it and never toward its base category.
+func categoryTag(record courseRecord, category sbcCategory, skip map[string]bool) string {
+	for _, tag := range category.Tags {
+		if !skip[tag] && sbcMatches(record, tag) {
+			return tag
+		}
+	}
+	return ""
+}
+
+// evaluateSBC fills a category from records. A course may count toward any
+// number of categories but only once within a category. Distinct areas are
+// assigned by matching courses to tags, completed courses first, before
+// extra courses fill out the count.
+func evaluateSBC(category sbcCategory, records []courseRecord, minGrade string) sbcCategoryProgress {
+	progress := sbcCategoryProgress{Id: category.Id, Name: category.Name, Needed: category.Count, CoursesUsed: []courseRecord{}}
+	if category.MinGrade != "" {
+		minGrade = category.MinGrade
+	}
+	var eligible []int
+	for i, record := range records {
+		if categoryTag(record, category, nil) == "" {
+			continue
+		}
+		if meetsGrade(record, minGrade) {
+			eligible = append(eligible, i)
+		} else if !record.InProgress {
+			progress.BelowGrade = append(progress.BelowGrade, record)
+		}
+	}
+	used := make(map[int]bool)
+	areas := make(map[string]bool)
+	completedAreas := 0
+	take := func(i int, tag string) {
+		used[i] = true
+		progress.CoursesUsed = append(progress.CoursesUsed, records[i])
+		if records[i].InProgress {
+			progress.InProgress++
+		} else {
+			progress.Completed++
+		}
+		if tag != "" && !areas[tag] {
+			areas[tag] = true
+			progress.Areas = append(progress.Areas, tag)
+			if !records[i].InProgress {
+				completedAreas++
+			}
+		}
+	}
+	matched := make(map[string]int)
+	var assign func(i int, seen map[string]bool) bool
+	assign = func(i int, seen map[string]bool) bool {
+		for _, tag := range category.Tags {
+			if seen[tag] || !sbcMatches(records[i], tag) {
+				continue
+			}
+			seen[tag] = true
+			if j, ok := matched[tag]; !ok || assign(j, seen) {
+				matched[tag] = i
+				return true
+			}
+		}
+		return false
+	}
+	for _, inProgress := range []bool{false, true} {
+		for _, i := range eligible {
+			if len(matched) >= category.Distinct {
+				break
+			}
+			if records[i].InProgress == inProgress {
+				assign(i, make(map[string]bool))
+			}
+		}
+	}
+	for _, tag := range category.Tags {
+		if i, ok := matched[tag]; ok {
+			take(i, tag)
+		}
+	}
+	for _, inProgress := range []bool{false, true} {
+		for _, i := range eligible {
+			if len(progress.CoursesUsed) >= category.Count {
+				break
+			}
+			if used[i] || records[i].InProgress != inProgress {
+				continue
+			}
+			tag := ""
+			if category.Distinct > 0 {
+				tag = categoryTag(records[i], category, areas)
+			}
+			take(i, tag)
+		}
+	}
+	switch {
+	case progress.Completed >= category.Count && completedAreas >= category.Distinct:
+		progress.Status = "satisfied"
+	case len(progress.CoursesUsed) >= category.Count && len(areas) >= category.Distinct:
+		progress.Status = "in_progress"
+	default:
+		progress.Status = "outstanding"
+		for _, tag := range category.Tags {
+			if len(areas) < category.Distinct && areas[tag] {
+				continue
+			}
+			progress.Missing = append(progress.Missing, tag)
+		}
+	}
+	return progress
+}
+
+func getOpenSections() ([]bson.M, error) {
+	collection := dbClient.Database(dbName).Collection("classes")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"size":       bson.M{"$gt": 0},
+		"course.sbc": bson.M{"$ne": ""},
+	}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch open sections: %v", err)
+	}
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func suggestSections(categories []sbcCategoryProgress, records []courseRecord, sections []bson.M) []sbcSuggestion {
+	taken := make(map[string]bool)
+	for _, record := range records {
+		if record.Grade == "F" {
+			continue
+		}
+		for _, alias := range record.aliases {
+			taken[alias] = true
+		}
+	}
+	suggestions := []sbcSuggestion{}
+	for _, section := range sections {
+		course, ok := section["course"].(bson.M)
+		if !ok {
+			continue
+		}
+		record := catalogRecord(course)
+		if len(record.aliases) == 0 || taken[record.aliases[0]] {
+			continue
+		}
+		var fills []string
+		for _, category := range categories {
+			for _, tag := range category.Missing {
+				if sbcMatches(record, tag) {
+					fills = append(fills, category.Id)
+					break
+				}
+			}
+		}
+		if len(fills) == 0 {
+			continue
+		}
+		seats, _ := numberValue(section["size"])
+		suggestions = append(suggestions, sbcSuggestion{
+			Section:    sectionKey(section),
+			Title:      fmt.Sprint(course["title"]),
+			SBC:        record.sbc,
+			Instructor: fmt.Sprint(section["instructor"]),
+			Seats:      int(seats),
+			Fills:      fills,
+		})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if len(suggestions[i].Fills) != len(suggestions[j].Fills) {
+			return len(suggestions[i].Fills) > len(suggestions[j].Fills)
+		}
+		return suggestions[i].Section < suggestions[j].Section
+	})
+	for i := range categories {
+		for _, suggestion := range suggestions {
+			for _, id := range suggestion.Fills {
+				if id == categories[i].Id {
+					categories[i].Suggestions = append(categories[i].Suggestions, suggestion.Section)
+				}
+			}
+		}
+	}
+	return suggestions
+}
+
+func getSBCProgress(id string) (sbcProgress, error) {
+	catalog, err := getCatalog()
+	if err != nil {
+		return sbcProgress{}, err
+	}
+	records, _, _, err := studentRecords(id, catalog)
+	if err != nil {
+		return sbcProgress{}, err
+	}
+	progress := sbcProgress{Term: appConfig.Term.Name, Categories: []sbcCategoryProgress{}}
+	for _, category := range degreeRequirements.SBC.Categories {
+		result := evaluateSBC(category, records, degreeRequirements.SBC.MinGrade)
+		if result.Status == "satisfied" {
+			progress.Satisfied++
+		}
+		progress.Categories = append(progress.Categories, result)
+	}
+	sections, err := getOpenSections()
+	if err != nil {
+		return sbcProgress{}, err
+	}
+	progress.Suggestions = suggestSections(progress.Categories, records, sections)
+	return progress, nil
+}
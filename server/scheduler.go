@@ -0,0 +1,380 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	maxScheduleCombinations = 200000
+	maxRankedSchedules      = 500
+	defaultSchedulePage     = 10
+	maxSchedulePage         = 50
+)
+
+type scheduleConstraints struct {
+	NoBefore string `json:"noBefore"`
+	NoAfter  string `json:"noAfter"`
+	DaysOff  string `json:"daysOff"`
+}
+
+type schedulePreferences struct {
+	Compact     bool     `json:"compact"`
+	Instructors []string `json:"instructors"`
+}
+
+type meeting struct {
+	Days      string
+	Start     int
+	End       int
+	Room      string
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+func (m meeting) overlaps(other meeting) bool {
+	if !strings.ContainsAny(m.Days, other.Days) || m.Start >= other.End || other.Start >= m.End {
+		return false
+	}
+	if !m.StartDate.IsZero() && !other.EndDate.IsZero() && m.StartDate.After(other.EndDate) {
+		return false
+	}
+	if !other.StartDate.IsZero() && !m.EndDate.IsZero() && other.StartDate.After(m.EndDate) {
+		return false
+	}
+	return true
+}
+
+type generatedSchedule struct {
+	sections []bson.M
+	score    float64
+	days     int
+	seq      int
+}
+
+func (s generatedSchedule) better(other generatedSchedule) bool {
+	if s.score != other.score {
+		return s.score > other.score
+	}
+	if s.days != other.days {
+		return s.days < other.days
+	}
+	return s.seq < other.seq
+}
+
+type scheduleHeap []generatedSchedule
+
+func (h scheduleHeap) Len() int            { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool  { return h[j].better(h[i]) }
+func (h scheduleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scheduleHeap) Push(x interface{}) { *h = append(*h, x.(generatedSchedule)) }
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func handleGenerateSchedules(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Courses     []string            `json:"courses"`
+		Constraints scheduleConstraints `json:"constraints"`
+		Preferences schedulePreferences `json:"preferences"`
+		Page        int                 `json:"page"`
+		PageSize    int                 `json:"pageSize"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Courses) == 0 {
+		http.Error(w, "At least one course is required", http.StatusBadRequest)
+		return
+	}
+	noBefore, noAfter, err := parseConstraintTimes(request.Constraints)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var options [][]bson.M
+	for _, name := range request.Courses {
+		parts := strings.Fields(name)
+		if len(parts) != 2 {
+			http.Error(w, "Invalid course "+name, http.StatusBadRequest)
+			return
+		}
+		sections, err := findOpenSections(parts[0], parts[1])
+		if err != nil {
+			http.Error(w, "Error with mongo returning sections", http.StatusInternalServerError)
+			return
+		}
+		var allowed []bson.M
+		for _, section := range sections {
+			if sectionFits(section, noBefore, noAfter, request.Constraints.DaysOff) {
+				allowed = append(allowed, section)
+			}
+		}
+		if len(allowed) == 0 {
+			sendConflict(w, "No open sections of "+name+" fit your constraints")
+			return
+		}
+		options = append(options, allowed)
+	}
+	page := request.Page
+	if page < 1 {
+		page = 1
+	}
+	pageSize := request.PageSize
+	if pageSize < 1 {
+		pageSize = defaultSchedulePage
+	}
+	if pageSize > maxSchedulePage {
+		pageSize = maxSchedulePage
+	}
+	schedules, total, truncated := enumerateSchedules(options, request.Preferences, min(page*pageSize, maxRankedSchedules))
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if start > len(schedules) {
+		start = len(schedules)
+	}
+	if end > len(schedules) {
+		end = len(schedules)
+	}
+	var results []map[string]interface{}
+	for _, schedule := range schedules[start:end] {
+		var classes []map[string]interface{}
+		for _, section := range schedule.sections {
+			response, ok := classResponse(section)
+			if !ok {
+				http.Error(w, "Invalid course data", http.StatusInternalServerError)
+				return
+			}
+			classes = append(classes, response)
+		}
+		results = append(results, map[string]interface{}{
+			"score":   schedule.score,
+			"classes": classes,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":     total,
+		"truncated": truncated,
+		"page":      page,
+		"pageSize":  pageSize,
+		"schedules": results,
+	})
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func parseConstraintTimes(constraints scheduleConstraints) (int, int, error) {
+	noBefore, noAfter := 0, 24*60
+	if constraints.NoBefore != "" {
+		parsed, err := time.Parse("15:04", constraints.NoBefore)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid noBefore time %s", constraints.NoBefore)
+		}
+		noBefore = parsed.Hour()*60 + parsed.Minute()
+	}
+	if constraints.NoAfter != "" {
+		parsed, err := time.Parse("15:04", constraints.NoAfter)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid noAfter time %s", constraints.NoAfter)
+		}
+		noAfter = parsed.Hour()*60 + parsed.Minute()
+	}
+	return noBefore, noAfter, nil
+}
+
+func sectionFits(section bson.M, noBefore int, noAfter int, daysOff string) bool {
+	for _, m := range sectionMeetings(section) {
+		if m.Start < noBefore || m.End > noAfter {
+			return false
+		}
+		if strings.ContainsAny(m.Days, daysOff) {
+			return false
+		}
+	}
+	return true
+}
+
+func sectionMeetings(section bson.M) []meeting {
+	blocks, _ := section["meetings"].(bson.A)
+	var meetings []meeting
+	for _, value := range blocks {
+		block, ok := value.(bson.M)
+		if !ok {
+			continue
+		}
+		start, ok := minutesOfDay(block["timeStart"])
+		if !ok {
+			continue
+		}
+		end, ok := minutesOfDay(block["timeEnd"])
+		if !ok {
+			continue
+		}
+		m := meeting{Start: start, End: end}
+		m.Days, _ = block["days"].(string)
+		m.Room, _ = block["room"].(string)
+		if startDate, ok := block["startDate"].(string); ok {
+			m.StartDate, _ = parseCampusDate(startDate)
+		}
+		if endDate, ok := block["endDate"].(string); ok {
+			m.EndDate, _ = parseCampusDate(endDate)
+		}
+		meetings = append(meetings, m)
+	}
+	return meetings
+}
+
+func minutesOfDay(value interface{}) (int, bool) {
+	wallClock, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+	t, err := time.Parse("15:04", wallClock)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func sectionsConflict(a bson.M, b bson.M) bool {
+	for _, ma := range sectionMeetings(a) {
+		for _, mb := range sectionMeetings(b) {
+			if ma.overlaps(mb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enumerateSchedules scores every conflict-free combination as it is found
+// and keeps only the best keep of them. It gives up after
+// maxScheduleCombinations, reporting the result as truncated.
+func enumerateSchedules(options [][]bson.M, preferences schedulePreferences, keep int) ([]generatedSchedule, int, bool) {
+	best := &scheduleHeap{}
+	total := 0
+	truncated := false
+	chosen := make([]bson.M, 0, len(options))
+	var walk func(int)
+	walk = func(i int) {
+		if truncated {
+			return
+		}
+		if i == len(options) {
+			if total >= maxScheduleCombinations {
+				truncated = true
+				return
+			}
+			schedule := generatedSchedule{sections: chosen, seq: total}
+			total++
+			scoreSchedule(&schedule, preferences)
+			if best.Len() < keep {
+				schedule.sections = append([]bson.M(nil), chosen...)
+				heap.Push(best, schedule)
+			} else if keep > 0 && schedule.better((*best)[0]) {
+				schedule.sections = append([]bson.M(nil), chosen...)
+				(*best)[0] = schedule
+				heap.Fix(best, 0)
+			}
+			return
+		}
+		for _, section := range options[i] {
+			conflict := false
+			for _, other := range chosen {
+				if sectionsConflict(section, other) {
+					conflict = true
+					break
+				}
+			}
+			if conflict {
+				continue
+			}
+			chosen = append(chosen, section)
+			walk(i + 1)
+			chosen = chosen[:len(chosen)-1]
+		}
+	}
+	walk(0)
+	schedules := []generatedSchedule(*best)
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].better(schedules[j])
+	})
+	return schedules, total, truncated
+}
+
+func scoreSchedule(schedule *generatedSchedule, preferences schedulePreferences) {
+	type span struct{ start, end, busy int }
+	spans := make(map[rune]*span)
+	for _, section := range schedule.sections {
+		for _, m := range sectionMeetings(section) {
+			for _, day := range m.Days {
+				s, ok := spans[day]
+				if !ok {
+					spans[day] = &span{start: m.Start, end: m.End, busy: m.End - m.Start}
+					continue
+				}
+				s.start = min(s.start, m.Start)
+				s.end = max(s.end, m.End)
+				s.busy += m.End - m.Start
+			}
+		}
+		if instructor, ok := section["instructor"].(string); ok {
+			for _, preferred := range preferences.Instructors {
+				if strings.EqualFold(instructor, preferred) {
+					schedule.score++
+					break
+				}
+			}
+		}
+	}
+	schedule.days = len(spans)
+	if preferences.Compact {
+		gaps := 0
+		for _, s := range spans {
+			gaps += s.end - s.start - s.busy
+		}
+		schedule.score -= float64(gaps) / 60
+	}
+}
+
+func findOpenSections(class string, code string) ([]bson.M, error) {
+	collection := dbClient.Database(dbName).Collection("classes")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"course.class": class,
+		"course.code":  code,
+		"size":         bson.M{"$gt": 0},
+	}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sections: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const seatHeartbeat = 25 * time.Second
+
+var (
+	errSeatsOpen     = errors.New("section still has open seats")
+	errNotWaitlisted = errors.New("not on the waitlist for this section")
+	errClassFull     = errors.New("class size is already at 0 or the class does not exist")
+)
+
+type seatEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+type seatSubscriber struct {
+	id       string
+	sections map[string]bool
+	events   chan seatEvent
+}
+
+type waitlistEntry struct {
+	Section primitive.ObjectID `bson:"section"`
+	Id      string             `bson:"id"`
+	Joined  time.Time          `bson:"joined"`
+}
+
+type seatHold struct {
+	ObjectId primitive.ObjectID `bson:"_id,omitempty"`
+	Section  primitive.ObjectID `bson:"section"`
+	Id       string             `bson:"id"`
+	Expires  time.Time          `bson:"expires"`
+}
+
+type seatHub struct {
+	mu          sync.Mutex
+	subscribers map[*seatSubscriber]bool
+}
+
+var seats = &seatHub{subscribers: make(map[*seatSubscriber]bool)}
+
+func (h *seatHub) subscribe(id string, sections []string) *seatSubscriber {
+	subscriber := &seatSubscriber{
+		id:       id,
+		sections: make(map[string]bool),
+		events:   make(chan seatEvent, 32),
+	}
+	for _, section := range sections {
+		subscriber.sections[section] = true
+	}
+	h.mu.Lock()
+	h.subscribers[subscriber] = true
+	h.mu.Unlock()
+	return subscriber
+}
+
+func (h *seatHub) unsubscribe(subscriber *seatSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, subscriber)
+	h.mu.Unlock()
+}
+
+func (h *seatHub) publish(event seatEvent, match func(*seatSubscriber) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for subscriber := range h.subscribers {
+		if !match(subscriber) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+		}
+	}
+}
+
+func seatCountEvent(section bson.M) seatEvent {
+	id := ""
+	if oid, ok := section["_id"].(primitive.ObjectID); ok {
+		id = oid.Hex()
+	}
+	size, _ := numberValue(section["size"])
+	maxSize, _ := numberValue(section["maxSize"])
+	return seatEvent{
+		name: "seats",
+		data: map[string]interface{}{
+			"section": id,
+			"size":    int(size),
+			"maxSize": int(maxSize),
+		},
+	}
+}
+
+func publishSeats(section bson.M) {
+	event := seatCountEvent(section)
+	id := event.data["section"].(string)
+	seats.publish(event, func(subscriber *seatSubscriber) bool {
+		return subscriber.sections[id]
+	})
+}
+
+func publishSeatsByID(ids []interface{}) {
+	if len(ids) == 0 {
+		return
+	}
+	collection := dbClient.Database(dbName).Collection("classes")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return
+	}
+	for _, result := range results {
+		publishSeats(result)
+	}
+}
+
+func publishCart(id string, classes []string) {
+	if classes == nil {
+		classes = []string{}
+	}
+	event := seatEvent{
+		name: "cart",
+		data: map[string]interface{}{
+			"id":      id,
+			"classes": classes,
+		},
+	}
+	seats.publish(event, func(subscriber *seatSubscriber) bool {
+		return subscriber.id == id
+	})
+}
+
+func publishWaitlist(section primitive.ObjectID) {
+	entries, err := getWaitlist(section)
+	if err != nil {
+		return
+	}
+	for i, entry := range entries {
+		event := seatEvent{
+			name: "waitlist",
+			data: map[string]interface{}{
+				"section":  section.Hex(),
+				"position": i + 1,
+				"length":   len(entries),
+			},
+		}
+		user := entry.Id
+		seats.publish(event, func(subscriber *seatSubscriber) bool {
+			return subscriber.id == user
+		})
+	}
+}
+
+func handleSeatEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	var sections []string
+	var ids []interface{}
+	for _, section := range strings.Split(r.URL.Query().Get("sections"), ",") {
+		if section == "" {
+			continue
+		}
+		oid, err := primitive.ObjectIDFromHex(section)
+		if err != nil {
+			http.Error(w, "Invalid section id "+section, http.StatusBadRequest)
+			return
+		}
+		sections = append(sections, section)
+		ids = append(ids, oid)
+	}
+	subscriber := seats.subscribe(id, sections)
+	defer seats.unsubscribe(subscriber)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	publishSeatsByID(ids)
+	if id != "" {
+		positions, err := getWaitlistPositions(id)
+		if err == nil {
+			for _, position := range positions {
+				select {
+				case subscriber.events <- seatEvent{name: "waitlist", data: position}:
+				default:
+				}
+			}
+		}
+	}
+	heartbeat := time.NewTicker(seatHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-subscriber.events:
+			data, err := json.Marshal(event.data)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func handleJoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id      string `json:"id"`
+		Section string `json:"section"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	section, err := primitive.ObjectIDFromHex(request.Section)
+	if err != nil {
+		http.Error(w, "Invalid section id", http.StatusBadRequest)
+		return
+	}
+	err = joinWaitlist(request.Id, section)
+	if errors.Is(err, errNoClass) {
+		http.Error(w, "Class doesn't exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errSeatsOpen) {
+		sendConflict(w, "This section still has open seats")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error joining waitlist", http.StatusInternalServerError)
+		return
+	}
+	publishWaitlist(section)
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleLeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id      string `json:"id"`
+		Section string `json:"section"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	section, err := primitive.ObjectIDFromHex(request.Section)
+	if err != nil {
+		http.Error(w, "Invalid section id", http.StatusBadRequest)
+		return
+	}
+	err = leaveWaitlist(request.Id, section)
+	if errors.Is(err, errNotWaitlisted) {
+		http.Error(w, "Not on the waitlist for this section", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error leaving waitlist", http.StatusInternalServerError)
+		return
+	}
+	publishWaitlist(section)
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetWaitlists(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	positions, err := getWaitlistPositions(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting waitlists", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(positions)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func joinWaitlist(id string, section primitive.ObjectID) error {
+	classes := dbClient.Database(dbName).Collection("classes")
+	collection := dbClient.Database(dbName).Collection("waitlists")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var class struct {
+		Size int `bson:"size"`
+	}
+	err := classes.FindOne(ctx, bson.M{"_id": section}).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errNoClass
+		}
+		return fmt.Errorf("failed to fetch class: %v", err)
+	}
+	if class.Size > 0 {
+		return errSeatsOpen
+	}
+	filter := bson.M{"section": section, "id": id}
+	update := bson.M{
+		"$setOnInsert": bson.M{"joined": time.Now()},
+	}
+	_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to join waitlist: %v", err)
+	}
+	return nil
+}
+
+func leaveWaitlist(id string, section primitive.ObjectID) error {
+	collection := dbClient.Database(dbName).Collection("waitlists")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.DeleteOne(ctx, bson.M{"section": section, "id": id})
+	if err != nil {
+		return fmt.Errorf("failed to leave waitlist: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return errNotWaitlisted
+	}
+	return nil
+}
+
+func getWaitlist(section primitive.ObjectID) ([]waitlistEntry, error) {
+	collection := dbClient.Database(dbName).Collection("waitlists")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "joined", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{"section": section}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch waitlist: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var results []waitlistEntry
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}
+
+func getWaitlistPositions(id string) ([]map[string]interface{}, error) {
+	collection := dbClient.Database(dbName).Collection("waitlists")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cursor, err := collection.Find(ctx, bson.M{"id": id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch waitlists: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var entries []waitlistEntry
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	positions := []map[string]interface{}{}
+	for _, entry := range entries {
+		waitlist, err := getWaitlist(entry.Section)
+		if err != nil {
+			return nil, err
+		}
+		for i, other := range waitlist {
+			if other.Id == id {
+				positions = append(positions, map[string]interface{}{
+					"section":  entry.Section.Hex(),
+					"position": i + 1,
+					"length":   len(waitlist),
+				})
+				break
+			}
+		}
+	}
+	return positions, nil
+}
+
+func seatHoldDuration() time.Duration {
+	minutes := appConfig.Notifications.HoldMinutes
+	if minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func claimSeat(ctx context.Context, filter bson.M, id string) (bson.M, bool, error) {
+	classes := dbClient.Database(dbName).Collection("classes")
+	holds := dbClient.Database(dbName).Collection("seatHolds")
+	waitlists := dbClient.Database(dbName).Collection("waitlists")
+	var class bson.M
+	err := classes.FindOne(ctx, filter).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, false, errNoClass
+		}
+		return nil, false, fmt.Errorf("failed to fetch class: %v", err)
+	}
+	sectionID := class["_id"]
+	held, err := holds.DeleteOne(ctx, bson.M{"section": sectionID, "id": id, "expires": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check seat hold: %v", err)
+	}
+	if held.DeletedCount == 0 {
+		update := bson.M{
+			"$inc": bson.M{
+				"size": -1,
+			},
+		}
+		err = classes.FindOneAndUpdate(ctx, bson.M{"_id": sectionID, "size": bson.M{"$gt": 0}}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&class)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, false, errClassFull
+			}
+			return nil, false, fmt.Errorf("failed to update class size: %v", err)
+		}
+	}
+	left, err := waitlists.DeleteOne(ctx, bson.M{"section": sectionID, "id": id})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to update waitlist: %v", err)
+	}
+	return class, left.DeletedCount > 0, nil
+}
+
+func releaseSeat(ctx context.Context, section primitive.ObjectID) (*seatHold, error) {
+	classes := dbClient.Database(dbName).Collection("classes")
+	holds := dbClient.Database(dbName).Collection("seatHolds")
+	waitlists := dbClient.Database(dbName).Collection("waitlists")
+	holders, err := holds.Distinct(ctx, "id", bson.M{"section": section})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch seat holds: %v", err)
+	}
+	if holders == nil {
+		holders = []interface{}{}
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "joined", Value: 1}, {Key: "_id", Value: 1}})
+	var next waitlistEntry
+	err = waitlists.FindOne(ctx, bson.M{"section": section, "id": bson.M{"$nin": holders}}, opts).Decode(&next)
+	if err == mongo.ErrNoDocuments {
+		_, err = classes.UpdateOne(ctx, bson.M{"_id": section}, bson.M{"$inc": bson.M{"size": 1}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to release seat: %v", err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch waitlist: %v", err)
+	}
+	hold := seatHold{
+		Section: section,
+		Id:      next.Id,
+		Expires: time.Now().Add(seatHoldDuration()),
+	}
+	_, err = holds.InsertOne(ctx, hold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hold seat: %v", err)
+	}
+	return &hold, nil
+}
+
+func hasSeatHold(id string, section interface{}) (bool, error) {
+	collection := dbClient.Database(dbName).Collection("seatHolds")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := collection.CountDocuments(ctx, bson.M{"section": section, "id": id, "expires": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return false, fmt.Errorf("failed to check seat hold: %v", err)
+	}
+	return count > 0, nil
+}
+
+func notifySeatHold(hold *seatHold) {
+	label := hold.Section.Hex()
+	class, err := searchClassByID(hold.Section)
+	if err == nil {
+		label = sectionKey(class)
+	}
+	notifyUser(hold.Id,
+		"A seat opened in "+label,
+		fmt.Sprintf("A seat in %s is being held for you until %s. Add it to your cart before then to keep it.",
+			label, hold.Expires.In(campusLocation).Format("Jan 2 3:04 PM MST")),
+		hold.Section.Hex())
+}
+
+func expireSeatHolds() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := releaseExpiredHolds()
+		if err != nil {
+			log.Printf("Error releasing expired seat holds: %v", err)
+		}
+	}
+}
+
+func releaseExpiredHolds() error {
+	holds := dbClient.Database(dbName).Collection("seatHolds")
+	waitlists := dbClient.Database(dbName).Collection("waitlists")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cursor, err := holds.Find(ctx, bson.M{"expires": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch expired holds: %v", err)
+	}
+	var expired []seatHold
+	if err = cursor.All(ctx, &expired); err != nil {
+		return fmt.Errorf("failed to decode results: %v", err)
+	}
+	for _, hold := range expired {
+		result, err := holds.DeleteOne(ctx, bson.M{"_id": hold.ObjectId})
+		if err != nil {
+			return fmt.Errorf("failed to delete hold: %v", err)
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+		_, err = waitlists.DeleteOne(ctx, bson.M{"section": hold.Section, "id": hold.Id})
+		if err != nil {
+			return fmt.Errorf("failed to update waitlist: %v", err)
+		}
+		notifyUser(hold.Id, "Seat hold expired", "The seat held for you has been released to the next student.", hold.Section.Hex())
+		err = releaseClassSize(hold.Section)
+		if err != nil {
+			return err
+		}
+		publishWaitlist(hold.Section)
+	}
+	return nil
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type shift struct {
+	EntryId primitive.ObjectID `bson:"_id,omitempty" json:"entryId"`
+	Id      string             `bson:"id" json:"-"`
+	TimeIn  time.Time          `bson:"timeIn" json:"timeIn"`
+	TimeOut time.Time          `bson:"timeOut" json:"timeOut"`
+	JobId   primitive.ObjectID `bson:"jobId,omitempty" json:"jobId,omitempty"`
+	Status  string             `bson:"status" json:"status"`
+	Source  string             `bson:"source,omitempty" json:"source,omitempty"`
+	Flags   []string           `bson:"flags,omitempty" json:"flags,omitempty"`
+	BatchId primitive.ObjectID `bson:"batchId,omitempty" json:"batchId,omitempty"`
+	Version int                `bson:"version" json:"version"`
+	Updated time.Time          `bson:"updated" json:"updated"`
+}
+
+type timesheetError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	EntryId string `json:"entryId,omitempty"`
+}
+
+type entryRequest struct {
+	Id      string `json:"id"`
+	EntryId string `json:"entryId"`
+	JobId   string `json:"jobId"`
+	Version int    `json:"version"`
+	TimeIn  string `json:"timeIn"`
+	TimeOut string `json:"timeOut"`
+}
+
+var (
+	errNoEntry        = errors.New("timesheet entry not found")
+	errStaleEntry     = errors.New("timesheet entry was changed by someone else")
+	errNoEntryVersion = errors.New("timesheet entry version is required")
+)
+
+func readEntryRequest(w http.ResponseWriter, r *http.Request) (entryRequest, bool) {
+	var request entryRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return request, false
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return request, false
+	}
+	if match := r.Header.Get("If-Match"); match != "" && request.Version == 0 {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+		if err != nil {
+			http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+			return request, false
+		}
+		request.Version = version
+	}
+	return request, true
+}
+
+func parseEntryTimes(request entryRequest) (shift, []timesheetError) {
+	var errs []timesheetError
+	timeIn, err := time.Parse(time.RFC3339, request.TimeIn)
+	if err != nil {
+		errs = append(errs, timesheetError{Field: "timeIn", Code: "invalid", Message: "timeIn must be an RFC 3339 timestamp"})
+	}
+	timeOut, err := time.Parse(time.RFC3339, request.TimeOut)
+	if err != nil {
+		errs = append(errs, timesheetError{Field: "timeOut", Code: "invalid", Message: "timeOut must be an RFC 3339 timestamp"})
+	}
+	var jobID primitive.ObjectID
+	if request.JobId != "" {
+		jobID, err = primitive.ObjectIDFromHex(request.JobId)
+		if err != nil {
+			errs = append(errs, timesheetError{Field: "jobId", Code: "invalid", Message: "jobId is not a valid job"})
+		}
+	}
+	return shift{Id: request.Id, TimeIn: timeIn, TimeOut: timeOut, JobId: jobID}, errs
+}
+
+func handleCreateTimesheetEntry(w http.ResponseWriter, r *http.Request) {
+	request, ok := readEntryRequest(w, r)
+	if !ok {
+		return
+	}
+	entry, errs := parseEntryTimes(request)
+	if len(errs) > 0 {
+		sendTimesheetErrors(w, errs)
+		return
+	}
+	existing, err := getTimesheet(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting timesheet", http.StatusInternalServerError)
+		return
+	}
+	jobs, err := getJobs(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting jobs", http.StatusInternalServerError)
+		return
+	}
+	errs = append(assignJob(&entry, jobs, existing), validateEntry(entry, existing)...)
+	locked, err := entryLocked(entry)
+	if err != nil {
+		http.Error(w, "Error checking pay period", http.StatusInternalServerError)
+		return
+	}
+	if locked {
+		errs = append(errs, lockedError())
+	}
+	if len(errs) > 0 {
+		sendTimesheetErrors(w, errs)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	entry.Source = "manual"
+	entry, err = createTimesheetEntry(ctx, entry)
+	if err != nil {
+		http.Error(w, "Error saving timesheet entry", http.StatusInternalServerError)
+		return
+	}
+	go checkAwardWarning(entry.Id, entry.JobId)
+	sendEntry(w, http.StatusCreated, entry)
+}
+
+func handleUpdateTimesheetEntry(w http.ResponseWriter, r *http.Request) {
+	request, ok := readEntryRequest(w, r)
+	if !ok {
+		return
+	}
+	entryID, err := primitive.ObjectIDFromHex(request.EntryId)
+	if err != nil {
+		http.Error(w, "Invalid entry id", http.StatusBadRequest)
+		return
+	}
+	entry, errs := parseEntryTimes(request)
+	if len(errs) > 0 {
+		sendTimesheetErrors(w, errs)
+		return
+	}
+	existing, err := getTimesheet(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting timesheet", http.StatusInternalServerError)
+		return
+	}
+	var current *shift
+	var others []shift
+	for i := range existing {
+		if existing[i].EntryId == entryID {
+			current = &existing[i]
+		} else {
+			others = append(others, existing[i])
+		}
+	}
+	if current == nil {
+		http.Error(w, "Timesheet entry doesn't exist", http.StatusNotFound)
+		return
+	}
+	jobs, err := getJobs(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting jobs", http.StatusInternalServerError)
+		return
+	}
+	entry.EntryId = entryID
+	entry.Status = current.Status
+	if entry.JobId.IsZero() {
+		entry.JobId = current.JobId
+	}
+	errs = append(assignJob(&entry, jobs, others), validateEntry(entry, others)...)
+	for _, check := range []shift{*current, entry} {
+		locked, err := entryLocked(check)
+		if err != nil {
+			http.Error(w, "Error checking pay period", http.StatusInternalServerError)
+			return
+		}
+		if locked {
+			errs = append(errs, lockedError())
+			break
+		}
+	}
+	if len(errs) > 0 {
+		sendTimesheetErrors(w, errs)
+		return
+	}
+	entry, err = updateTimesheetEntry(entry, request.Version)
+	if err != nil {
+		sendEntryError(w, err)
+		return
+	}
+	go checkAwardWarning(entry.Id, entry.JobId)
+	sendEntry(w, http.StatusOK, entry)
+}
+
+func handleDeleteTimesheetEntry(w http.ResponseWriter, r *http.Request) {
+	request, ok := readEntryRequest(w, r)
+	if !ok {
+		return
+	}
+	entryID, err := primitive.ObjectIDFromHex(request.EntryId)
+	if err != nil {
+		http.Error(w, "Invalid entry id", http.StatusBadRequest)
+		return
+	}
+	current, err := getTimesheetEntry(request.Id, entryID)
+	if err != nil {
+		sendEntryError(w, err)
+		return
+	}
+	locked, err := entryLocked(current)
+	if err != nil {
+		http.Error(w, "Error checking pay period", http.StatusInternalServerError)
+		return
+	}
+	if locked {
+		sendTimesheetErrors(w, []timesheetError{lockedError()})
+		return
+	}
+	err = deleteTimesheetEntry(request.Id, entryID, request.Version)
+	if err != nil {
+		sendEntryError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGetTimesheet(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading req body", http.StatusInternalServerError)
+		return
+	}
+	var request struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		http.Error(w, "Error parsing JSON req body", http.StatusBadRequest)
+		return
+	}
+	timesheet, err := getTimesheet(request.Id)
+	if err != nil {
+		http.Error(w, "Error with getting timesheet", http.StatusInternalServerError)
+		return
+	}
+	periods, err := groupTimesheet(request.Id, timesheet, time.Now())
+	if err != nil {
+		http.Error(w, "Error with getting timesheet", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(periods)
+	if err != nil {
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	}
+}
+
+func sendEntry(w http.ResponseWriter, status int, entry shift) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(entry.Version)))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func sendEntryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoEntry):
+		http.Error(w, "Timesheet entry doesn't exist", http.StatusNotFound)
+	case errors.Is(err, errNoEntryVersion):
+		http.Error(w, "A version or If-Match header is required", http.StatusPreconditionRequired)
+	case errors.Is(err, errStaleEntry):
+		http.Error(w, "Timesheet entry was changed elsewhere, reload and try again", http.StatusPreconditionFailed)
+	default:
+		http.Error(w, "Error saving timesheet entry", http.StatusInternalServerError)
+	}
+}
+
+func sendTimesheetErrors(w http.ResponseWriter, errs []timesheetError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Timesheet entry is invalid",
+		"errors": errs,
+	})
+}
+
+func lockedError() timesheetError {
+	return timesheetError{Field: "timeIn", Code: "period_locked", Message: "Entries can't be added, changed or removed after the pay period's deadline or once it is submitted or approved"}
+}
+
+func hoursLimit(hours float64, fallback float64) time.Duration {
+	if hours <= 0 {
+		hours = fallback
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
+
+func weekStart(t time.Time) string {
+	local := t.In(campusLocation)
+	offset := (int(local.Weekday()) + 6) % 7
+	return local.AddDate(0, 0, -offset).Format("2006-01-02")
+}
+
+func entryLocked(entry shift) (bool, error) {
+	if entry.Status == "S" || entry.Status == "A" || entry.Status == entryStatuses["late"] {
+		return true, nil
+	}
+	period, err := payPeriodFor(entry.TimeIn)
+	if err == errNoPayPeriod {
+		return true, nil
+	}
+	status, err := getPeriodStatus(entry.Id, period.key())
+	if err != nil {
+		return false, err
+	}
+	return !periodEditable(period, status, time.Now()), nil
+}
+
+func validateEntry(entry shift, others []shift) []timesheetError {
+	var errs []timesheetError
+	maxShift := hoursLimit(appConfig.Timesheets.MaxShiftHours, 6)
+	maxDay := hoursLimit(appConfig.Timesheets.MaxDayHours, 8)
+	maxWeek := hoursLimit(appConfig.Timesheets.MaxWeekHours, 20)
+	if !entry.TimeOut.After(entry.TimeIn) {
+		return []timesheetError{{Field: "timeOut", Code: "order", Message: "timeOut must be after timeIn"}}
+	}
+	worked := entry.TimeOut.Sub(entry.TimeIn)
+	if worked > maxShift {
+		errs = append(errs, timesheetError{Code: "shift_limit", Message: fmt.Sprintf("Shifts can be at most %g hours", maxShift.Hours())})
+	}
+	day := entry.TimeIn.In(campusLocation).Format("2006-01-02")
+	week := weekStart(entry.TimeIn)
+	dayTotal, weekTotal := worked, worked
+	for _, other := range others {
+		if entry.TimeIn.Before(other.TimeOut) && other.TimeIn.Before(entry.TimeOut) {
+			errs = append(errs, timesheetError{Code: "overlap", Message: "Shift overlaps another entry", EntryId: other.EntryId.Hex()})
+		}
+		if other.TimeIn.In(campusLocation).Format("2006-01-02") == day {
+			dayTotal += other.TimeOut.Sub(other.TimeIn)
+		}
+		if weekStart(other.TimeIn) == week {
+			weekTotal += other.TimeOut.Sub(other.TimeIn)
+		}
+	}
+	if dayTotal > maxDay {
+		errs = append(errs, timesheetError{Code: "day_limit", Message: fmt.Sprintf("More than %g hours worked on %s", maxDay.Hours(), day)})
+	}
+	if weekTotal > maxWeek {
+		errs = append(errs, timesheetError{Code: "week_limit", Message: fmt.Sprintf("More than %g hours worked in the week of %s", maxWeek.Hours(), week)})
+	}
+	return errs
+}
+
+func createTimesheetEntry(ctx context.Context, entry shift) (shift, error) {
+	collection := dbClient.Database(dbName).Collection("timesheets")
+	if entry.Status != entryStatuses["late"] {
+		entry.Status = ""
+	}
+	entry.Version = 1
+	entry.Updated = time.Now()
+	result, err := collection.InsertOne(ctx, entry)
+	if err != nil {
+		return shift{}, fmt.Errorf("failed to insert timesheet entry for user with id %s: %v", entry.Id, err)
+	}
+	entry.EntryId = result.InsertedID.(primitive.ObjectID)
+	return entry, nil
+}
+
+func updateTimesheetEntry(entry shift, version int) (shift, error) {
+	if version == 0 {
+		return shift{}, errNoEntryVersion
+	}
+	collection := dbClient.Database(dbName).Collection("timesheets")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": entry.EntryId, "id": entry.Id, "version": version}
+	update := bson.M{
+		"$set": bson.M{"timeIn": entry.TimeIn, "timeOut": entry.TimeOut, "jobId": entry.JobId, "updated": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated shift
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		_, err = getTimesheetEntry(entry.Id, entry.EntryId)
+		if err != nil {
+			return shift{}, err
+		}
+		return shift{}, errStaleEntry
+	}
+	if err != nil {
+		return shift{}, fmt.Errorf("failed to update timesheet entry: %v", err)
+	}
+	return updated, nil
+}
+
+func deleteTimesheetEntry(id string, entryID primitive.ObjectID, version int) error {
+	if version == 0 {
+		return errNoEntryVersion
+	}
+	collection := dbClient.Database(dbName).Collection("timesheets")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": entryID, "id": id, "version": version})
+	if err != nil {
+		return fmt.Errorf("failed to delete timesheet entry: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		_, err = getTimesheetEntry(id, entryID)
+		if err != nil {
+			return err
+		}
+		return errStaleEntry
+	}
+	return nil
+}
+
+func ensureTimesheetIndexes(ctx context.Context, db *mongo.Database) {
+	_, err := db.Collection("timesheets").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "id", Value: 1}, {Key: "timeIn", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create timesheets index: %v", err)
+	}
+}
+
+func getTimesheetEntry(id string, entryID primitive.ObjectID) (shift, error) {
+	collection := dbClient.Database(dbName).Collection("timesheets")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var entry shift
+	err := collection.FindOne(ctx, bson.M{"_id": entryID, "id": id}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return shift{}, errNoEntry
+	}
+	if err != nil {
+		return shift{}, fmt.Errorf("failed to fetch timesheet entry: %v", err)
+	}
+	return entry, nil
+}
+
+func getTimesheet(id string) ([]shift, error) {
+	collection := dbClient.Database(dbName).Collection("timesheets")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.M{"timeIn": 1})
+	cursor, err := collection.Find(ctx, bson.M{"id": id}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch timesheet: %v", err)
+	}
+	defer cursor.Close(ctx)
+	results := []shift{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %v", err)
+	}
+	return results, nil
+}